@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"slices"
+	"time"
+)
+
+// ============================================================================
+// INTEGRITY: Per-user audit trail
+// ============================================================================
+//
+// Events about one account (login, record changes, exports, retention
+// purges, ...) are written as [AUDIT] log lines and kept in the user's trail,
+// which the user receives with their data export (export.go). The trail
+// holds no health values, only actions and record IDs. Erasure deletes it and
+// then records the erasure itself, so the trail of an erased account holds
+// that one entry. Events without a user (unknown email at login, retention
+// run summaries) are only logged.
+
+// Audit actions
+const (
+	auditUserRegistered  = "user.registered"
+	auditUserLoggedIn    = "user.logged_in"
+	auditUserLoggedOut   = "user.logged_out"
+	auditLoginFailed     = "user.login_failed"
+	auditUserDeactivated = "user.deactivated"
+	auditLegalHold       = "user.legal_hold"
+	auditPasswordReset   = "user.password_reset"
+	auditErasureRefused  = "user.erasure_refused"
+	auditUserErased      = "user.erased"
+	auditRecordCreated   = "health.created"
+	auditRecordUpdated   = "health.updated"
+	auditRecordDeleted   = "health.deleted"
+	auditRecordRestored  = "health.restored"
+	auditRecordsImported = "health.imported"
+	auditDataExported    = "data.exported"
+	auditRecordsPurged   = "health.purged" // retention period passed
+	auditDeletedSwept    = "health.swept"  // deleted past the restore window
+)
+
+// AuditEntry is one event in a user's trail
+type AuditEntry struct {
+	Action string    `json:"action"`
+	Detail string    `json:"detail"`
+	At     time.Time `json:"at"`
+}
+
+// AuditStore keeps the per-user trails (PostgreSQL when configured, else the KV store)
+type AuditStore interface {
+	Append(ctx context.Context, userID string, entry *AuditEntry) error
+	List(ctx context.Context, userID string) ([]*AuditEntry, error) // oldest first
+	DeleteAllForUser(ctx context.Context, userID string) error
+}
+
+var auditStore AuditStore
+
+// initAuditStore selects the backend like initHealthStore
+func initAuditStore(db *sql.DB) {
+	if db == nil {
+		auditStore = kvAuditStore{}
+		infof("[STORE] Audit trail: key-value store (no database configured)")
+		return
+	}
+	auditStore = postgresAuditStore{db: db}
+	infof("[STORE] Audit trail: PostgreSQL")
+}
+
+// auditEvent logs an [AUDIT] line and appends it to the user's trail. A
+// failed append is logged; the request it describes has already succeeded.
+func auditEvent(ctx context.Context, userID, action, format string, args ...interface{}) {
+	detail := fmt.Sprintf(format, args...)
+	log.Printf("[AUDIT] %s", detail)
+	entry := &AuditEntry{Action: action, Detail: detail, At: time.Now().UTC()}
+	if err := auditStore.Append(ctx, userID, entry); err != nil {
+		log.Printf("[STORE] Failed to record audit entry for %s: %v", userID, err)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// Key-value store: audit:<user_id> is a list, newest first
+// ----------------------------------------------------------------------------
+
+type kvAuditStore struct{}
+
+func auditKey(userID string) string {
+	return "audit:" + userID
+}
+
+func (kvAuditStore) Append(ctx context.Context, userID string, entry *AuditEntry) error {
+	raw, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return kv.LPush(ctx, auditKey(userID), string(raw))
+}
+
+func (kvAuditStore) List(ctx context.Context, userID string) ([]*AuditEntry, error) {
+	items, err := kv.LRange(ctx, auditKey(userID))
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]*AuditEntry, 0, len(items))
+	for _, item := range items {
+		var entry AuditEntry
+		if err := json.Unmarshal([]byte(item), &entry); err != nil {
+			return nil, err
+		}
+		entries = append(entries, &entry)
+	}
+	slices.Reverse(entries)
+	return entries, nil
+}
+
+func (kvAuditStore) DeleteAllForUser(ctx context.Context, userID string) error {
+	_, err := kv.Del(ctx, auditKey(userID))
+	return err
+}
+
+// ----------------------------------------------------------------------------
+// PostgreSQL: audit_log table (migrations/0006_create_audit_log.up.sql)
+// ----------------------------------------------------------------------------
+
+type postgresAuditStore struct {
+	db *sql.DB
+}
+
+func (s postgresAuditStore) Append(ctx context.Context, userID string, entry *AuditEntry) error {
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO audit_log (user_id, action, detail, created_at) VALUES ($1, $2, $3, $4)`,
+		userID, entry.Action, entry.Detail, entry.At)
+	return err
+}
+
+func (s postgresAuditStore) List(ctx context.Context, userID string) ([]*AuditEntry, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT action, detail, created_at FROM audit_log WHERE user_id = $1 ORDER BY id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var entries []*AuditEntry
+	for rows.Next() {
+		var entry AuditEntry
+		if err := rows.Scan(&entry.Action, &entry.Detail, &entry.At); err != nil {
+			return nil, err
+		}
+		entries = append(entries, &entry)
+	}
+	return entries, rows.Err()
+}
+
+func (s postgresAuditStore) DeleteAllForUser(ctx context.Context, userID string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM audit_log WHERE user_id = $1`, userID)
+	return err
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"errors"
+	"log"
 	"net/http"
 	"strings"
 	"time"
@@ -9,30 +11,92 @@ import (
 	"github.com/golang-jwt/jwt/v4"
 )
 
+// authMiddleware picks the authentication scheme: HMAC-signed requests
+// (device gateways) or JWT (bearer header / session cookie).
+func authMiddleware(next http.Handler) http.Handler {
+	signed := signedRequestMiddleware(next)
+	bearer := jwtMiddleware(next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(signatureHeader) != "" {
+			signed.ServeHTTP(w, r)
+			return
+		}
+		bearer.ServeHTTP(w, r)
+	})
+}
+
+// userOnlyMiddleware refuses gateways (signed requests acting for a patient)
+// on routes they do not need: they may only add records (see router.go)
+func userOnlyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if method, _ := r.Context().Value("auth_method").(string); method == authMethodSignature {
+			client, _ := r.Context().Value("client").(string)
+			log.Printf("[SECURITY] Rejected signed request (route not allowed for gateways): key=%q %s %s", client, r.Method, r.URL.Path)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 func jwtMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		authHeader := r.Header.Get("Authorization")
-		if !strings.HasPrefix(authHeader, "Bearer ") {
+		tokenStr, method := tokenFromRequest(r)
+		if tokenStr == "" {
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
 
-		tokenStr := strings.TrimPrefix(authHeader, "Bearer ")
 		claims := &jwt.RegisteredClaims{}
 
-		token, err := jwt.ParseWithClaims(tokenStr, claims, func(token *jwt.Token) (interface{}, error) {
-			return GetJWTSecret(), nil
-		})
+		token, err := parseJWT(tokenStr, claims)
 		if err != nil || !token.Valid {
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
 
 		ctx := context.WithValue(r.Context(), "user", claims.Subject)
+		ctx = context.WithValue(ctx, "auth_method", method)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+// tokenFromRequest reads the JWT from the Authorization header, falling back to
+// the browser session cookie. Bearer wins if both are present.
+func tokenFromRequest(r *http.Request) (string, string) {
+	if authHeader := r.Header.Get("Authorization"); authHeader != "" {
+		if !strings.HasPrefix(authHeader, "Bearer ") {
+			return "", ""
+		}
+		return strings.TrimPrefix(authHeader, "Bearer "), authMethodBearer
+	}
+	if cookie, err := r.Cookie(sessionCookieName); err == nil && cookie.Value != "" {
+		return cookie.Value, authMethodCookie
+	}
+	return "", ""
+}
+
+// parseJWT verifies the token with the current secret, falling back to the
+// previous one for tokens issued shortly before a secret rotation
+func parseJWT(tokenStr string, claims *jwt.RegisteredClaims) (*jwt.Token, error) {
+	keyFunc := func(secret []byte) jwt.Keyfunc {
+		return func(token *jwt.Token) (interface{}, error) {
+			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+				return nil, jwt.ErrTokenSignatureInvalid
+			}
+			return secret, nil
+		}
+	}
+	token, err := jwt.ParseWithClaims(tokenStr, claims, keyFunc(GetJWTSecret()))
+	if previous := getPreviousJWTSecret(); err != nil && previous != nil {
+		var verr *jwt.ValidationError
+		if errors.As(err, &verr) && verr.Errors&jwt.ValidationErrorSignatureInvalid != 0 {
+			return jwt.ParseWithClaims(tokenStr, claims, keyFunc(previous))
+		}
+	}
+	return token, err
+}
+
 // Generate token contoh (dipanggil dari handlers.go sebagai generateJWT)
 func generateJWT(userID string) (string, error) {
 	claims := &jwt.RegisteredClaims{
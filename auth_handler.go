@@ -48,16 +48,6 @@ func registerHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check if user already exists (INTEGRITY)
-	userKey := "user:" + req.Email
-	if _, err := rdb.Get(r.Context(), userKey).Result(); err == nil {
-		w.WriteHeader(http.StatusConflict)
-		json.NewEncoder(w).Encode(map[string]string{
-			"error": "Email already registered",
-		})
-		return
-	}
-
 	// Hash password (CONFIDENTIALITY)
 	hashedPassword, err := HashPassword(req.Password)
 	if err != nil {
@@ -75,16 +65,22 @@ func registerHandler(w http.ResponseWriter, r *http.Request) {
 		Email:     req.Email,
 		Password:  hashedPassword,
 		FullName:  req.FullName,
+		Role:      roleUser,
 		Active:    true,
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
 	}
 
-	// Store user in cache (AVAILABILITY: fast retrieval)
-	userJSON, _ := json.Marshal(user)
-	ttl := 24 * time.Hour
-	if err := rdb.Set(r.Context(), userKey, userJSON, ttl).Err(); err != nil {
-		log.Printf("[AUTH] Failed to store user in cache")
+	// Store the user; the store rejects a taken email atomically (see user_store.go)
+	if err := userStore.Create(r.Context(), user); err != nil {
+		if err == errEmailTaken {
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error": "Email already registered",
+			})
+			return
+		}
+		log.Printf("[AUTH] Failed to store user: %v", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(map[string]string{
 			"error": "Failed to register user",
@@ -93,7 +89,7 @@ func registerHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Log registration attempt (INTEGRITY: audit trail) - use user ID only, not email
-	log.Printf("[AUDIT] User registered: %s", user.ID)
+	auditEvent(r.Context(), user.ID, auditUserRegistered, "User registered: %s", user.ID)
 
 	// Generate JWT token
 	token, err := generateJWT(user.ID)
@@ -105,10 +101,7 @@ func registerHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Return success response (no password exposed)
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(AuthResponse{
+	resp := AuthResponse{
 		Token:     token,
 		ExpiresIn: 3600, // 1 hour
 		User: &User{
@@ -117,7 +110,18 @@ func registerHandler(w http.ResponseWriter, r *http.Request) {
 			FullName: user.FullName,
 			Active:   user.Active,
 		},
-	})
+	}
+
+	// Browser session: token lives in HttpOnly cookie, never in the body (CONFIDENTIALITY)
+	if req.Session == sessionModeCookie {
+		setSessionCookie(w, token)
+		resp.Token = ""
+	}
+
+	// Return success response (no password exposed)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(resp)
 }
 
 // loginHandler authenticates a user and returns a JWT token
@@ -154,11 +158,9 @@ func loginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Retrieve user (AVAILABILITY: cache-first)
-	userKey := "user:" + req.Email
-	userJSON, err := rdb.Get(r.Context(), userKey).Result()
-	if err != nil {
-		// User not found or Redis error
+	// Retrieve user via blind index (CONFIDENTIALITY: email never used as a key)
+	user, err := userStore.GetByEmail(r.Context(), req.Email)
+	if err == errUserNotFound {
 		log.Printf("[AUTH] Login attempt failed: user not found")
 		w.WriteHeader(http.StatusUnauthorized)
 		json.NewEncoder(w).Encode(map[string]string{
@@ -166,9 +168,8 @@ func loginHandler(w http.ResponseWriter, r *http.Request) {
 		})
 		return
 	}
-
-	var user User
-	if err := json.Unmarshal([]byte(userJSON), &user); err != nil {
+	if err != nil {
+		log.Printf("[AUTH] Failed to load user: %v", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(map[string]string{
 			"error": "Failed to process login",
@@ -178,7 +179,7 @@ func loginHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Verify password (CONFIDENTIALITY: constant-time comparison)
 	if !VerifyPassword(user.Password, req.Password) {
-		log.Printf("[AUDIT] Failed login attempt (invalid credentials)")
+		auditEvent(r.Context(), user.ID, auditLoginFailed, "Failed login attempt (invalid credentials): %s", user.ID)
 		w.WriteHeader(http.StatusUnauthorized)
 		json.NewEncoder(w).Encode(map[string]string{
 			"error": "Invalid email or password",
@@ -188,7 +189,7 @@ func loginHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Check if user is active (INTEGRITY)
 	if !user.Active {
-		log.Printf("[AUDIT] Login attempt by inactive user")
+		auditEvent(r.Context(), user.ID, auditLoginFailed, "Login attempt by inactive user: %s", user.ID)
 		w.WriteHeader(http.StatusForbidden)
 		json.NewEncoder(w).Encode(map[string]string{
 			"error": "User account is inactive",
@@ -207,12 +208,9 @@ func loginHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Log successful login (INTEGRITY: audit trail) - use user ID only, not email
-	log.Printf("[AUDIT] User logged in: %s", user.ID)
+	auditEvent(r.Context(), user.ID, auditUserLoggedIn, "User logged in: %s", user.ID)
 
-	// Return success response
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(AuthResponse{
+	resp := AuthResponse{
 		Token:     token,
 		ExpiresIn: 3600, // 1 hour
 		User: &User{
@@ -221,12 +219,23 @@ func loginHandler(w http.ResponseWriter, r *http.Request) {
 			FullName: user.FullName,
 			Active:   user.Active,
 		},
-	})
+	}
+
+	// Browser session: token lives in HttpOnly cookie, never in the body (CONFIDENTIALITY)
+	if req.Session == sessionModeCookie {
+		setSessionCookie(w, token)
+		resp.Token = ""
+	}
+
+	// Return success response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
 }
 
 // logoutHandler invalidates a user's session
 // POST /api/v1/auth/logout (protected)
-// Note: JWT is stateless; logout clears cache/client-side token
+// Note: JWT is stateless; logout clears session cookie/client-side token
 func logoutHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -243,7 +252,10 @@ func logoutHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	log.Printf("[AUDIT] User logged out: %s", userID)
+	auditEvent(r.Context(), userID, auditUserLoggedOut, "User logged out: %s", userID)
+
+	// Drop browser session cookies (no-op for bearer clients)
+	clearSessionCookie(w)
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
@@ -279,3 +291,87 @@ func meHandler(w http.ResponseWriter, r *http.Request) {
 		"status":  "authenticated",
 	})
 }
+
+// eraseAccountHandler erases the current user's account and health data (right to erasure)
+// DELETE /api/v1/auth/me (protected)
+// CONFIDENTIALITY: Crypto-shredding - the user's data key is destroyed first, so
+// the encrypted records left anywhere (replicas, and backups that do not also
+// hold the key, see envelope.go) become unreadable.
+func eraseAccountHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := r.Context().Value("user").(string)
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "Unauthorized",
+		})
+		return
+	}
+
+	// Only the user can erase their account, not a gateway acting for them
+	if method, _ := r.Context().Value("auth_method").(string); method == authMethodSignature {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "Account erasure requires the user's own session",
+		})
+		return
+	}
+
+	// A legal hold (retention.go) blocks erasure until it is released. Fail
+	// closed: if the hold cannot be checked, nothing is erased.
+	user, err := userStore.GetByID(r.Context(), userID)
+	if err != nil && err != errUserNotFound {
+		log.Printf("[AUTH] Legal hold check failed for %s, not erasing: %v", userID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "Failed to erase account data",
+		})
+		return
+	}
+	if err == nil && user.LegalHold {
+		auditEvent(r.Context(), userID, auditErasureRefused, "Erasure refused, user under legal hold: %s", userID)
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "Account is under legal hold and cannot be erased",
+		})
+		return
+	}
+
+	// Destroy the data key before deleting anything else
+	if err := shredUserKey(r.Context(), userID); err != nil {
+		log.Printf("[AUTH] Failed to shred data key for %s: %v", userID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "Failed to erase account data",
+		})
+		return
+	}
+
+	// Best-effort removal of the (now undecryptable) profile and records
+	if err := userStore.Delete(r.Context(), userID); err != nil {
+		log.Printf("[AUTH] Failed to delete user %s: %v", userID, err)
+	}
+
+	erased, err := healthStore.DeleteAllForUser(r.Context(), userID)
+	if err != nil {
+		log.Printf("[AUTH] Failed to delete health records of %s: %v", userID, err)
+	}
+	healthRecordsChanged(r.Context(), userID)
+	if err := auditStore.DeleteAllForUser(r.Context(), userID); err != nil {
+		log.Printf("[AUTH] Failed to delete audit trail of %s: %v", userID, err)
+	}
+
+	clearSessionCookie(w)
+	// Written after the trail was deleted: the erasure stays on record
+	auditEvent(r.Context(), userID, auditUserErased, "User data erased (key shredded): %s, %d records", userID, erased)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Account data erased",
+	})
+}
@@ -2,18 +2,102 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log"
-
-	"github.com/go-redis/redis/v8"
+	"time"
 )
 
-var rdb *redis.Client
+// initStorage opens the key-value backend selected by storage.backend.
+// Must run after InitSecurityConfig. An unreachable Redis is reported as an
+// error instead of exiting, so callers decide how to fail.
+func initStorage(cfg *Config) error {
+	switch cfg.Storage.Backend {
+	case "memory":
+		// Data keys live in the KV store unless keystore.backend=redis: losing
+		// them on restart would make everything encrypted in PostgreSQL unreadable
+		if cfg.Database.DSN != "" && keyProvider != nil && cfg.Keystore.Backend == "kv" {
+			return fmt.Errorf("storage.backend=memory cannot be combined with a database and encryption (data keys would be lost on restart; set keystore.backend=redis)")
+		}
+		if cfg.Environment == "production" {
+			return fmt.Errorf("storage.backend=memory is not allowed in production")
+		}
+		kv = newMemoryKVStore(time.Minute)
+		warnf("[STORE] In-memory storage: data is lost on restart and not shared between replicas")
+		initCaches(cfg.Cache, cfg.Server.RequestTimeout.D())
+		return initKeystore(cfg)
+	default:
+		client, err := newRedisClient(cfg.Redis)
+		if err != nil {
+			return err
+		}
+		store := newRedisKVStore(client, cfg.Redis.KeyPrefix)
+		if err := store.Ping(context.Background()); err != nil {
+			store.Close()
+			return fmt.Errorf("redis unreachable: %w (set STORAGE_BACKEND=memory to run without Redis)", err)
+		}
+		if cfg.Redis.KeyPrefix != "" {
+			infof("[STORE] Redis key prefix: %q", cfg.Redis.KeyPrefix)
+		}
+		// Evicting data keys (or records kept without a database) loses data for good
+		holdsKeys := keyProvider != nil && cfg.Keystore.Backend == "kv"
+		if holdsKeys || cfg.Database.DSN == "" {
+			if err := requireNoEviction(store, "redis"); err != nil {
+				store.Close()
+				return err
+			}
+		}
+		kv = store
+		initCaches(cfg.Cache, cfg.Server.RequestTimeout.D())
+		return initKeystore(cfg)
+	}
+}
+
+// initKeystore opens the store for wrapped data keys (keystore.backend).
+// A separate keystore keeps the keys out of the data's Redis/PostgreSQL
+// backups, so erasure also reaches those backups.
+func initKeystore(cfg *Config) error {
+	if cfg.Keystore.Backend != "redis" {
+		dekStore, separateKeystore = kv, false
+		if keyProvider != nil && cfg.Environment == "production" {
+			log.Println("[SECURITY WARNING] Data keys are stored with the data (keystore.backend=kv): backups taken before an erasure stay decryptable")
+		}
+		return nil
+	}
+	rc := cfg.Redis
+	rc.Mode, rc.Addr, rc.Addrs, rc.DB = "standalone", cfg.Keystore.Addr, nil, cfg.Keystore.DB
+	rc.Username, rc.Password, rc.TLS, rc.TLSServerName = cfg.Keystore.Username, cfg.Keystore.Password, cfg.Keystore.TLS, ""
+	client, err := newRedisClient(rc)
+	if err != nil {
+		return fmt.Errorf("keystore: %w", err)
+	}
+	store := newRedisKVStore(client, cfg.Redis.KeyPrefix)
+	if err := store.Ping(context.Background()); err != nil {
+		store.Close()
+		return fmt.Errorf("keystore redis unreachable: %w", err)
+	}
+	if err := requireNoEviction(store, "keystore"); err != nil {
+		store.Close()
+		return err
+	}
+	dekStore, separateKeystore = store, true
+	infof("[STORE] Data keys: separate keystore %s, db %d", cfg.Keystore.Addr, cfg.Keystore.DB)
+	return nil
+}
 
-func initRedis(addr string) {
-	rdb = redis.NewClient(&redis.Options{
-		Addr: addr,
-	})
-	if err := rdb.Ping(context.Background()).Err(); err != nil {
-		log.Fatalf("Gagal koneksi Redis: %v", err)
+// requireNoEviction refuses a Redis that may evict keys under memory pressure
+func requireNoEviction(store *redisKVStore, name string) error {
+	policy, err := store.MaxmemoryPolicy(context.Background())
+	if err != nil {
+		return fmt.Errorf("%s: cannot read maxmemory-policy: %w", name, err)
 	}
+	if policy != "noeviction" {
+		return fmt.Errorf("%s: maxmemory-policy is %q; it must be noeviction, or evicted data keys/records are lost for good", name, policy)
+	}
+	return nil
+}
+
+// initCaches applies cache settings and creates the shared caches
+func initCaches(cfg CacheConfig, loadTimeout time.Duration) {
+	cacheSettings, cacheLoadTimeout = cfg, loadTimeout
+	healthStatsCache = newCache[HealthStats]("stats", cfg.StatsTTL.D(), errNoHealthStats, healthStatsCodec())
 }
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	crand "crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ============================================================================
+// Cache-aside layer (AVAILABILITY): typed get-or-compute over the KV store
+// ============================================================================
+//
+// - Concurrent misses for one key in this process share a single computation
+//   (singleflight), so a burst after a write costs one query, not one each.
+//   It runs detached from the first caller's context (bounded by the request
+//   timeout), so that caller going away does not fail the others; a panic
+//   in it is returned to all of them as an error
+// - TTLs are jittered so entries written together do not expire together
+// - A loader error matching notFound is cached for the (shorter) negative TTL
+// - Invalidate replaces the entry with a marker holding a new random
+//   generation. A computation writes back only if the entry is still what it
+//   read before loading (SetNX if there was none, else a compare-and-set), so
+//   a miss in any process cannot write back data loaded before a write. A
+//   computation outliving the marker's TTL (the cache TTL) is not detected.
+//
+// Entries live under cache:<name>:<key>, apart from primary data.
+
+// CacheStatus is what Get did, reported in X-Cache
+type CacheStatus string
+
+const (
+	cacheHit       CacheStatus = "HIT"       // served from the cache (value or cached not-found)
+	cacheMiss      CacheStatus = "MISS"      // computed here and stored
+	cacheCoalesced CacheStatus = "COALESCED" // waited for a concurrent computation of the same key
+	cacheBypass    CacheStatus = "BYPASS"    // computed without caching (disabled, store error, invalidated meanwhile)
+)
+
+// cacheSettings are the process-wide defaults (see initCaches)
+var cacheSettings = CacheConfig{NegativeTTL: Duration(30 * time.Second), JitterPercent: 10}
+
+// cacheLoadTimeout bounds a shared computation (server.request_timeout)
+var cacheLoadTimeout = 30 * time.Second
+
+// cacheCodec converts values to stored bytes; key is the caller's key
+// (without prefix), for codecs that need the owner (e.g. to encrypt)
+type cacheCodec[T any] struct {
+	encode func(ctx context.Context, key string, v *T) ([]byte, error)
+	decode func(ctx context.Context, key string, data []byte) (*T, error)
+}
+
+// Cache is a typed cache-aside view of the KV store
+type Cache[T any] struct {
+	name     string
+	ttl      time.Duration // 0 disables the cache
+	notFound error         // loader error to cache negatively (nil = never)
+	codec    cacheCodec[T]
+	flights  flightGroup[T]
+}
+
+// newCache creates a cache; notFound may be nil to disable negative caching
+func newCache[T any](name string, ttl time.Duration, notFound error, codec cacheCodec[T]) *Cache[T] {
+	return &Cache[T]{name: name, ttl: ttl, notFound: notFound, codec: codec}
+}
+
+// Stored entries start with a marker byte
+const (
+	cacheValueMarker       = 'v'
+	cacheNotFoundMarker    = 'n'
+	cacheInvalidatedMarker = 'i' // followed by the generation
+)
+
+// errCacheEntryChanged aborts a write-back over an entry changed since it was read
+var errCacheEntryChanged = errors.New("cache entry changed")
+
+func (c *Cache[T]) storeKey(key string) string {
+	return "cache:" + c.name + ":" + key
+}
+
+// Get returns the cached value for key or computes it with load. Loader
+// errors are returned as is (a cached not-found returns c.notFound).
+func (c *Cache[T]) Get(ctx context.Context, key string, load func(context.Context) (*T, error)) (*T, CacheStatus, error) {
+	if c.ttl <= 0 {
+		v, err := load(ctx)
+		return v, cacheBypass, err
+	}
+
+	full := c.storeKey(key)
+	raw, err := kv.Get(ctx, full)
+	switch {
+	case err == nil && len(raw) > 0 && raw[0] == cacheNotFoundMarker:
+		return nil, cacheHit, c.notFound
+	case err == nil && len(raw) > 0 && raw[0] == cacheValueMarker:
+		if v, err := c.codec.decode(ctx, key, raw[1:]); err == nil {
+			return v, cacheHit, nil
+		}
+		// Undecodable (format change, shredded key): recompute and overwrite
+	case err != nil && err != errKeyNotFound:
+		warnf("[CACHE] %s read failed: %v", c.name, err)
+	}
+	var seen []byte // what the write-back must still find (nil = no entry)
+	if err == nil {
+		seen = raw
+	}
+
+	res, shared := c.flights.do(full, func(stale func() bool) flightResult[T] {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), cacheLoadTimeout)
+		defer cancel()
+		v, err := load(ctx)
+		status := cacheMiss
+		switch {
+		case stale():
+			status = cacheBypass // invalidated while computing; do not write back
+		case err == nil:
+			if !c.write(ctx, key, seen, v) {
+				status = cacheBypass
+			}
+		case c.notFound != nil && errors.Is(err, c.notFound):
+			if !c.writeNotFound(ctx, key, seen) {
+				status = cacheBypass
+			}
+		}
+		return flightResult[T]{value: v, err: err, status: status}
+	})
+	if shared {
+		return copyOf(res.value), cacheCoalesced, res.err
+	}
+	return res.value, res.status, res.err
+}
+
+// Set stores a value obtained elsewhere (e.g. priming the by-ID entry after
+// a lookup by another key) unless there is an entry or invalidation marker
+func (c *Cache[T]) Set(ctx context.Context, key string, v *T) {
+	if c.ttl > 0 {
+		c.write(ctx, key, nil, v)
+	}
+}
+
+// Invalidate replaces entries with a new generation marker (see the file
+// comment) and marks this process's running computations for them stale
+func (c *Cache[T]) Invalidate(ctx context.Context, keys ...string) {
+	if c.ttl <= 0 {
+		return
+	}
+	for _, key := range keys {
+		full := c.storeKey(key)
+		c.flights.forget(full)
+		generation := make([]byte, 16)
+		crand.Read(generation)
+		if err := kv.Set(ctx, full, append([]byte{cacheInvalidatedMarker}, generation...), c.ttl); err != nil {
+			warnf("[CACHE] %s invalidation failed: %v", c.name, err)
+		}
+	}
+}
+
+// write stores v if the entry is still seen (nil: absent; see put)
+func (c *Cache[T]) write(ctx context.Context, key string, seen []byte, v *T) bool {
+	data, err := c.codec.encode(ctx, key, v)
+	if err != nil {
+		warnf("[CACHE] %s encode failed: %v", c.name, err)
+		return false
+	}
+	return c.put(ctx, key, seen, append([]byte{cacheValueMarker}, data...), c.ttl)
+}
+
+func (c *Cache[T]) writeNotFound(ctx context.Context, key string, seen []byte) bool {
+	ttl := cacheSettings.NegativeTTL.D()
+	if ttl <= 0 {
+		return false
+	}
+	return c.put(ctx, key, seen, []byte{cacheNotFoundMarker}, ttl)
+}
+
+// put writes data only if the entry is unchanged since it was read as seen:
+// SetNX when there was none, else a compare-and-set. A lost race is not an
+// error, the entry was invalidated or written by someone else.
+func (c *Cache[T]) put(ctx context.Context, key string, seen, data []byte, ttl time.Duration) bool {
+	full, ttl := c.storeKey(key), jitterTTL(ttl)
+	var err error
+	if seen == nil {
+		var stored bool
+		if stored, err = kv.SetNX(ctx, full, data, ttl); err == nil && !stored {
+			return false
+		}
+	} else {
+		err = kv.Update(ctx, full, func(current []byte) ([]byte, error) {
+			if !bytes.Equal(current, seen) {
+				return nil, errCacheEntryChanged
+			}
+			return data, nil
+		})
+		switch err {
+		case nil:
+			err = kv.Expire(ctx, full, ttl) // Update keeps the old entry's TTL
+		case errCacheEntryChanged, errKeyChanged, errKeyNotFound:
+			return false
+		}
+	}
+	if err != nil {
+		warnf("[CACHE] %s write failed: %v", c.name, err)
+		return false
+	}
+	return true
+}
+
+// jitterTTL spreads ttl by ±cache.jitter_percent
+func jitterTTL(ttl time.Duration) time.Duration {
+	spread := int64(ttl) * int64(cacheSettings.JitterPercent) / 100
+	if spread <= 0 {
+		return ttl
+	}
+	return ttl + time.Duration(rand.Int63n(2*spread+1)-spread)
+}
+
+// copyOf gives each coalesced caller its own copy, so one caller modifying
+// the result cannot affect another
+func copyOf[T any](v *T) *T {
+	if v == nil {
+		return nil
+	}
+	cp := *v
+	return &cp
+}
+
+// jsonCacheCodec stores values as plain JSON
+func jsonCacheCodec[T any]() cacheCodec[T] {
+	return cacheCodec[T]{
+		encode: func(_ context.Context, _ string, v *T) ([]byte, error) {
+			return json.Marshal(v)
+		},
+		decode: func(_ context.Context, _ string, data []byte) (*T, error) {
+			v := new(T)
+			if err := json.Unmarshal(data, v); err != nil {
+				return nil, err
+			}
+			return v, nil
+		},
+	}
+}
+
+// ----------------------------------------------------------------------------
+// Singleflight: one computation per key at a time within this process
+// ----------------------------------------------------------------------------
+
+type flightResult[T any] struct {
+	value  *T
+	err    error
+	status CacheStatus
+}
+
+type flightCall[T any] struct {
+	done   chan struct{}
+	result flightResult[T]
+	stale  atomic.Bool
+}
+
+type flightGroup[T any] struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall[T]
+}
+
+// do runs fn once for concurrent callers of key; shared reports whether this
+// caller received another caller's result. fn can ask whether the key was
+// invalidated since it started.
+func (g *flightGroup[T]) do(key string, fn func(stale func() bool) flightResult[T]) (result flightResult[T], shared bool) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*flightCall[T])
+	}
+	if call, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		<-call.done
+		return call.result, true
+	}
+	call := &flightCall[T]{done: make(chan struct{})}
+	g.calls[key] = call
+	g.mu.Unlock()
+
+	defer func() {
+		g.mu.Lock()
+		if g.calls[key] == call {
+			delete(g.calls, key)
+		}
+		g.mu.Unlock()
+		close(call.done)
+	}()
+	call.result = runFlight(key, fn, call.stale.Load)
+	return call.result, false
+}
+
+// runFlight calls fn, turning a panic into an error: waiters would otherwise
+// get an empty result that looks like a success
+func runFlight[T any](key string, fn func(stale func() bool) flightResult[T], stale func() bool) (result flightResult[T]) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("[CACHE] Computation of %s panicked: %v\n%s", key, r, debug.Stack())
+			result = flightResult[T]{err: fmt.Errorf("cache computation panicked: %v", r), status: cacheBypass}
+		}
+	}()
+	return fn(stale)
+}
+
+// forget marks a running computation of key stale and detaches it, so
+// callers arriving after an invalidation start a fresh one
+func (g *flightGroup[T]) forget(key string) {
+	g.mu.Lock()
+	if call, ok := g.calls[key]; ok {
+		call.stale.Store(true)
+		delete(g.calls, key)
+	}
+	g.mu.Unlock()
+}
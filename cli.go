@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// ============================================================================
+// Command-line interface: every command loads configuration the same way
+// (defaults < --config file < environment < flags, see config.go), so
+// --config, --print-config and the config flags work everywhere.
+// ============================================================================
+
+const cliUsage = `Usage: server <command> [flags]
+
+Commands:
+  serve                      run the HTTPS API server (default)
+  migrate up|down|status     apply, revert or list schema and data migrations
+  user create-admin          create an administrator account
+  user deactivate            block a user from logging in
+  user legal-hold            place or release a legal hold on a user's data
+  user set-password          set a user's password (e.g. after a legacy migration)
+  retention purge            delete health records past their retention period
+  cert generate              create a self-signed TLS certificate for testing
+  keys rotate                re-encrypt data under the current master key
+  export user                write a user's profile, health records and audit trail as JSON
+  config check               validate configuration and secrets
+
+Run "server <command> -h" for the flags of a command.
+`
+
+// runCLI dispatches os.Args[1:] to a command
+func runCLI(args []string) {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") && args[0] != "-h" && args[0] != "--help" {
+		runServe(args)
+		return
+	}
+
+	cmd, rest := args[0], args[1:]
+	switch cmd {
+	case "serve":
+		runServe(rest)
+	case "migrate":
+		runSubcommand(cmd, rest, map[string]func([]string){
+			"up":     runMigrateUp,
+			"down":   runMigrateDown,
+			"status": runMigrateStatus,
+		})
+	case "user":
+		runSubcommand(cmd, rest, map[string]func([]string){
+			"create-admin": runUserCreateAdmin,
+			"deactivate":   runUserDeactivate,
+			"legal-hold":   runUserLegalHold,
+			"set-password": runUserSetPassword,
+		})
+	case "retention":
+		runSubcommand(cmd, rest, map[string]func([]string){
+			"purge": runRetentionPurge,
+		})
+	case "cert":
+		runSubcommand(cmd, rest, map[string]func([]string){
+			"generate": runCertGenerate,
+		})
+	case "keys":
+		runSubcommand(cmd, rest, map[string]func([]string){
+			"rotate": runRotateKeys,
+		})
+	case "export":
+		runSubcommand(cmd, rest, map[string]func([]string){
+			"user": runExportUser,
+		})
+	case "config":
+		runSubcommand(cmd, rest, map[string]func([]string){
+			"check": runConfigCheck,
+		})
+
+	// Pre-CLI command names, kept for existing scripts
+	case "rotate-keys":
+		log.Println("[CLI] rotate-keys is deprecated; use `server keys rotate`")
+		runRotateKeys(rest)
+	case "migrate-users":
+		log.Println("[CLI] migrate-users is deprecated; use `server migrate up`")
+		runMigrateUp(rest)
+
+	case "help", "-h", "--help":
+		fmt.Print(cliUsage)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", cmd, cliUsage)
+		os.Exit(2)
+	}
+}
+
+// runSubcommand dispatches "<group> <name> [flags]"
+func runSubcommand(group string, args []string, commands map[string]func([]string)) {
+	if len(args) == 0 || commands[args[0]] == nil {
+		names := slices.Sorted(maps.Keys(commands))
+		fmt.Fprintf(os.Stderr, "usage: server %s <%s> [flags]\n", group, strings.Join(names, "|"))
+		os.Exit(2)
+	}
+	commands[args[0]](args[1:])
+}
+
+// loadCommandConfig parses a command's flags together with the config flags
+func loadCommandConfig(fs *flag.FlagSet, args []string) *Config {
+	cfg, err := LoadConfig(fs, args)
+	if err != nil {
+		log.Fatalf("[CONFIG] %v", err)
+	}
+	return cfg
+}
+
+// initCommand prepares security, the KV store and the stores for operational
+// commands; db is nil without DATABASE_URL
+func initCommand(cfg *Config) (context.Context, *sql.DB) {
+	InitSecurityConfig(cfg)
+	if err := initStorage(cfg); err != nil {
+		log.Fatalf("[STORE] %v", err)
+	}
+	db := openDB(cfg.Database)
+	initUserStore(db, cfg.Database)
+	initHealthStore(db)
+	initAuditStore(db)
+	initRetention(cfg.Retention)
+	return context.Background(), db
+}
+
+// ----------------------------------------------------------------------------
+// cert generate
+// ----------------------------------------------------------------------------
+
+// runCertGenerate implements `server cert generate [--force]`
+func runCertGenerate(args []string) {
+	fs := flag.NewFlagSet("cert generate", flag.ExitOnError)
+	force := fs.Bool("force", false, "overwrite an existing certificate")
+	cfg := loadCommandConfig(fs, args)
+
+	certFile, keyFile := cfg.Server.CertFile, cfg.Server.KeyFile
+	if _, err := os.Stat(certFile); err == nil && !*force {
+		log.Fatalf("[CERT] %s already exists (use --force to replace it)", certFile)
+	}
+	if cfg.Environment == "production" {
+		log.Println("[CERT WARNING] Self-signed certificates are for testing; use a CA-issued certificate in production")
+	}
+	for _, dir := range []string{filepath.Dir(certFile), filepath.Dir(keyFile)} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			log.Fatalf("[CERT] %v", err)
+		}
+	}
+	if err := generateSelfSignedCert(certFile, keyFile); err != nil {
+		log.Fatalf("[CERT] Gagal membuat sertifikat: %v", err)
+	}
+	log.Printf("[CERT] Self-signed certificate written to %s (key: %s)", certFile, keyFile)
+}
+
+// ----------------------------------------------------------------------------
+// config check
+// ----------------------------------------------------------------------------
+
+// runConfigCheck implements `server config check`: it loads and validates the
+// configuration, resolves secrets and keys, and checks the TLS files.
+// Exits non-zero on the first fatal problem.
+func runConfigCheck(args []string) {
+	cfg := loadCommandConfig(flag.NewFlagSet("config check", flag.ExitOnError), args)
+	InitSecurityConfig(cfg) // fatal on missing production secrets or a bad keyring
+
+	problems := 0
+	for _, file := range []string{cfg.Server.CertFile, cfg.Server.KeyFile} {
+		if _, err := os.Stat(file); err != nil {
+			log.Printf("[CONFIG] TLS file %s: %v", file, err)
+			problems++
+		}
+	}
+	if problems > 0 {
+		log.Fatalf("[CONFIG] %d problem(s) found", problems)
+	}
+	log.Println("[CONFIG] Configuration OK")
+}
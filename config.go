@@ -0,0 +1,500 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ============================================================================
+// Configuration: defaults < file (YAML/JSON) < environment < flags
+// ============================================================================
+//
+// Field tags:
+//   env:"NAME"      environment variable override (secret fields also accept
+//                   NAME_FILE and /run/secrets, see secrets.go)
+//   flag:"name"     command-line override (--name)
+//   secret:"true"   redacted by --print-config
+//   validate:"..."  schema rules (go-playground/validator)
+
+// Config is the typed application configuration
+type Config struct {
+	Environment string          `json:"environment" yaml:"environment" env:"ENVIRONMENT" flag:"env" validate:"omitempty,oneof=development staging production"`
+	Server      ServerConfig    `json:"server" yaml:"server"`
+	Database    DatabaseConfig  `json:"database" yaml:"database"`
+	Storage     StorageConfig   `json:"storage" yaml:"storage"`
+	Redis       RedisConfig     `json:"redis" yaml:"redis"`
+	Keystore    KeystoreConfig  `json:"keystore" yaml:"keystore"`
+	Cache       CacheConfig     `json:"cache" yaml:"cache"`
+	Retention   RetentionConfig `json:"retention" yaml:"retention"`
+	Import      ImportConfig    `json:"import" yaml:"import"`
+	Export      ExportConfig    `json:"export" yaml:"export"`
+	Security    SecuritySection `json:"security" yaml:"security"`
+	RateLimit   RateLimitConfig `json:"rate_limit" yaml:"rate_limit"`
+	Log         LogConfig       `json:"log" yaml:"log"`
+}
+
+// ServerConfig controls the HTTPS listener
+type ServerConfig struct {
+	Addr                  string   `json:"addr" yaml:"addr" env:"LISTEN_ADDR" flag:"addr" validate:"required"`
+	CertFile              string   `json:"cert_file" yaml:"cert_file" env:"TLS_CERT_FILE" flag:"cert-file" validate:"required"`
+	KeyFile               string   `json:"key_file" yaml:"key_file" env:"TLS_KEY_FILE" flag:"key-file" validate:"required"`
+	ReadTimeout           Duration `json:"read_timeout" yaml:"read_timeout" env:"SERVER_READ_TIMEOUT" validate:"gt=0"`
+	WriteTimeout          Duration `json:"write_timeout" yaml:"write_timeout" env:"SERVER_WRITE_TIMEOUT" validate:"gt=0"`
+	IdleTimeout           Duration `json:"idle_timeout" yaml:"idle_timeout" env:"SERVER_IDLE_TIMEOUT" validate:"gt=0"`
+	ShutdownTimeout       Duration `json:"shutdown_timeout" yaml:"shutdown_timeout" env:"SERVER_SHUTDOWN_TIMEOUT" validate:"gt=0"`
+	RequestTimeout        Duration `json:"request_timeout" yaml:"request_timeout" env:"REQUEST_TIMEOUT" validate:"gt=0"`
+	MaxConcurrentRequests int      `json:"max_concurrent_requests" yaml:"max_concurrent_requests" env:"MAX_CONCURRENT_REQUESTS" validate:"min=1"`
+}
+
+// DatabaseConfig controls the optional PostgreSQL connection
+type DatabaseConfig struct {
+	DSN          string `json:"dsn" yaml:"dsn" env:"DATABASE_URL" flag:"database-url" secret:"true"`
+	MaxOpenConns int    `json:"max_open_conns" yaml:"max_open_conns" env:"DB_MAX_OPEN_CONNS" validate:"min=1"`
+	MaxIdleConns int    `json:"max_idle_conns" yaml:"max_idle_conns" env:"DB_MAX_IDLE_CONNS" validate:"min=0,ltefield=MaxOpenConns"`
+	// UserCacheTTL enables the Redis read cache for users (0 = off)
+	UserCacheTTL Duration `json:"user_cache_ttl" yaml:"user_cache_ttl" env:"USER_CACHE_TTL" validate:"gte=0"`
+	// AutoMigrate applies pending schema migrations at startup (otherwise run `server migrate up`)
+	AutoMigrate bool `json:"auto_migrate" yaml:"auto_migrate" env:"DB_AUTO_MIGRATE"`
+}
+
+// StorageConfig selects the key-value backend (see kv_store.go)
+type StorageConfig struct {
+	// Backend is "redis" or "memory" (single process, lost on restart; development and tests)
+	Backend string `json:"backend" yaml:"backend" env:"STORAGE_BACKEND" flag:"storage" validate:"oneof=redis memory"`
+}
+
+// RedisConfig controls the Redis connection (storage.backend=redis, see redis_client.go)
+type RedisConfig struct {
+	// Mode is standalone (Addr), sentinel (Addrs = sentinels + MasterName) or cluster (Addrs = seed nodes)
+	Mode       string   `json:"mode" yaml:"mode" env:"REDIS_MODE" validate:"oneof=standalone sentinel cluster"`
+	Addr       string   `json:"addr" yaml:"addr" env:"REDIS_ADDR" flag:"redis-addr" validate:"required_if=Mode standalone,omitempty,hostname_port"`
+	Addrs      []string `json:"addrs" yaml:"addrs" env:"REDIS_ADDRS" validate:"required_unless=Mode standalone,dive,hostname_port"`
+	MasterName string   `json:"master_name" yaml:"master_name" env:"REDIS_MASTER_NAME" validate:"required_if=Mode sentinel"`
+	// Username/Password authenticate with AUTH (ACL user when Username is set)
+	Username         string   `json:"username" yaml:"username" env:"REDIS_USERNAME"`
+	Password         string   `json:"password" yaml:"password" env:"REDIS_PASSWORD" secret:"true"`
+	SentinelUsername string   `json:"sentinel_username" yaml:"sentinel_username" env:"REDIS_SENTINEL_USERNAME"`
+	SentinelPassword string   `json:"sentinel_password" yaml:"sentinel_password" env:"REDIS_SENTINEL_PASSWORD" secret:"true"`
+	DB               int      `json:"db" yaml:"db" env:"REDIS_DB" validate:"min=0"`
+	TLS              bool     `json:"tls" yaml:"tls" env:"REDIS_TLS"`
+	TLSCAFile        string   `json:"tls_ca_file" yaml:"tls_ca_file" env:"REDIS_TLS_CA_FILE"`
+	TLSServerName    string   `json:"tls_server_name" yaml:"tls_server_name" env:"REDIS_TLS_SERVER_NAME"`
+	DialTimeout      Duration `json:"dial_timeout" yaml:"dial_timeout" env:"REDIS_DIAL_TIMEOUT" validate:"gt=0"`
+	ReadTimeout      Duration `json:"read_timeout" yaml:"read_timeout" env:"REDIS_READ_TIMEOUT" validate:"gt=0"`
+	WriteTimeout     Duration `json:"write_timeout" yaml:"write_timeout" env:"REDIS_WRITE_TIMEOUT" validate:"gt=0"`
+	PoolSize         int      `json:"pool_size" yaml:"pool_size" env:"REDIS_POOL_SIZE" validate:"min=1"`
+	MinIdleConns     int      `json:"min_idle_conns" yaml:"min_idle_conns" env:"REDIS_MIN_IDLE_CONNS" validate:"min=0,ltefield=PoolSize"`
+	// KeyPrefix is prepended to every key (e.g. "staging:") so environments can share one Redis
+	KeyPrefix string `json:"key_prefix" yaml:"key_prefix" env:"REDIS_KEY_PREFIX"`
+}
+
+// KeystoreConfig selects where wrapped data keys are kept (see envelope.go)
+type KeystoreConfig struct {
+	// Backend "kv" keeps them in the main store; "redis" uses a separate
+	// standalone Redis, backed up apart from the data (CA file and timeouts
+	// as for redis)
+	Backend  string `json:"backend" yaml:"backend" env:"KEYSTORE_BACKEND" validate:"oneof=kv redis"`
+	Addr     string `json:"addr" yaml:"addr" env:"KEYSTORE_REDIS_ADDR" validate:"required_if=Backend redis,omitempty,hostname_port"`
+	Username string `json:"username" yaml:"username" env:"KEYSTORE_REDIS_USERNAME"`
+	Password string `json:"password" yaml:"password" env:"KEYSTORE_REDIS_PASSWORD" secret:"true"`
+	DB       int    `json:"db" yaml:"db" env:"KEYSTORE_REDIS_DB" validate:"min=0"`
+	TLS      bool   `json:"tls" yaml:"tls" env:"KEYSTORE_REDIS_TLS"`
+}
+
+// CacheConfig tunes the cache-aside layer (see cache_aside.go)
+type CacheConfig struct {
+	// StatsTTL is how long health stats are cached (0 = off)
+	StatsTTL Duration `json:"stats_ttl" yaml:"stats_ttl" env:"CACHE_STATS_TTL" validate:"gte=0"`
+	// NegativeTTL is how long "not found" results are cached (0 = off)
+	NegativeTTL   Duration `json:"negative_ttl" yaml:"negative_ttl" env:"CACHE_NEGATIVE_TTL" validate:"gte=0"`
+	JitterPercent int      `json:"jitter_percent" yaml:"jitter_percent" env:"CACHE_JITTER_PERCENT" validate:"min=0,max=50"`
+}
+
+// RetentionConfig controls how long health records are kept (see retention.go).
+// Periods are "forever", a Go duration ("720h") or days/years ("90d", "1y").
+type RetentionConfig struct {
+	// Rules maps a record type to its period, e.g. weight: forever, temperature: 1y
+	// (env: RETENTION_RULES=weight=forever,temperature=1y)
+	Rules map[string]string `json:"rules" yaml:"rules" env:"RETENTION_RULES" validate:"dive,keys,oneof=blood_pressure heart_rate weight temperature glucose,endkeys,retention_period"`
+	// Default applies to types without a rule
+	Default string `json:"default" yaml:"default" env:"RETENTION_DEFAULT" validate:"retention_period"`
+	// Interval between scheduled purges (0 = off; run `server retention purge` instead)
+	Interval Duration `json:"interval" yaml:"interval" env:"RETENTION_INTERVAL" validate:"gte=0"`
+	// DryRun makes scheduled purges only report what they would delete
+	DryRun bool `json:"dry_run" yaml:"dry_run" env:"RETENTION_DRY_RUN"`
+	// RestoreWindow is how long a deleted record can be restored before the sweeper removes it
+	RestoreWindow Duration `json:"restore_window" yaml:"restore_window" env:"RETENTION_RESTORE_WINDOW" validate:"gt=0"`
+	// SweepInterval between sweeps of deleted records past the window (0 = off)
+	SweepInterval Duration `json:"sweep_interval" yaml:"sweep_interval" env:"RETENTION_SWEEP_INTERVAL" validate:"gte=0"`
+}
+
+// ImportConfig limits bulk imports of health records (health_import.go, FHIR Bundles in fhir.go)
+type ImportConfig struct {
+	// MaxBytes caps the request body (CSV or JSON); imports with an
+	// Idempotency-Key or signature are also capped by security.max_request_body_size
+	MaxBytes int64 `json:"max_bytes" yaml:"max_bytes" env:"IMPORT_MAX_BYTES" validate:"min=1024"`
+	// MaxRows caps the number of records per import
+	MaxRows int `json:"max_rows" yaml:"max_rows" env:"IMPORT_MAX_ROWS" validate:"min=1"`
+}
+
+// ExportConfig controls personal data exports (see export.go)
+type ExportConfig struct {
+	// AsyncThreshold is the record count above which an export runs as a
+	// background job instead of streaming
+	AsyncThreshold int `json:"async_threshold" yaml:"async_threshold" env:"EXPORT_ASYNC_THRESHOLD" validate:"min=0"`
+	// LinkTTL is how long a finished job's download link stays valid
+	LinkTTL Duration `json:"link_ttl" yaml:"link_ttl" env:"EXPORT_LINK_TTL" validate:"gt=0"`
+}
+
+// SecuritySection holds non-secret security settings (secrets: see secrets.go)
+type SecuritySection struct {
+	AllowedOrigins       []string `json:"allowed_origins" yaml:"allowed_origins" env:"ALLOWED_ORIGINS" validate:"min=1,dive,required"`
+	RequireHTTPS         bool     `json:"require_https" yaml:"require_https" env:"REQUIRE_HTTPS"`
+	RedirectHost         string   `json:"redirect_host" yaml:"redirect_host" env:"REDIRECT_HOST" validate:"required"`
+	RedirectAllowedHosts []string `json:"redirect_allowed_hosts" yaml:"redirect_allowed_hosts" env:"REDIRECT_ALLOWED_HOSTS" validate:"dive,required"`
+	MaxRequestBodySize   int64    `json:"max_request_body_size" yaml:"max_request_body_size" env:"MAX_REQUEST_BODY_SIZE" validate:"min=1024"`
+	CSRFTokenExpiry      Duration `json:"csrf_token_expiry" yaml:"csrf_token_expiry" env:"CSRF_TOKEN_EXPIRY" validate:"gt=0"`
+	CSRFStore            string   `json:"csrf_store" yaml:"csrf_store" env:"CSRF_STORE" validate:"oneof=memory redis"`
+	SignatureMaxSkew     Duration `json:"signature_max_skew" yaml:"signature_max_skew" env:"SIGNATURE_MAX_SKEW" validate:"gt=0"`
+	// SigningPatients lists, per signing key ID, the user IDs a gateway may
+	// act for (space separated; env: SIGNING_PATIENTS=gw-01=<id> <id>,gw-02=<id>)
+	SigningPatients      map[string]string `json:"signing_patients" yaml:"signing_patients" env:"SIGNING_PATIENTS"`
+	SecretReloadInterval Duration          `json:"secret_reload_interval" yaml:"secret_reload_interval" env:"SECRET_RELOAD_INTERVAL" validate:"gt=0"`
+	EncryptionKeyVersion string            `json:"encryption_key_version" yaml:"encryption_key_version" env:"ENCRYPTION_KEY_VERSION" validate:"required"`
+	KeyringFile          string            `json:"keyring_file" yaml:"keyring_file" env:"KEYRING_FILE"`
+	EncryptHealthValues  bool              `json:"encrypt_health_values" yaml:"encrypt_health_values" env:"ENCRYPT_HEALTH_VALUES"`
+}
+
+// RateLimitConfig holds per-IP rate-limit tiers
+type RateLimitConfig struct {
+	RequestsPerMinute     int `json:"requests_per_minute" yaml:"requests_per_minute" env:"RATE_LIMIT_PER_MINUTE" flag:"rate-limit" validate:"min=1"`
+	AuthRequestsPerMinute int `json:"auth_requests_per_minute" yaml:"auth_requests_per_minute" env:"RATE_LIMIT_AUTH_PER_MINUTE" validate:"min=1"`
+}
+
+// LogConfig controls log verbosity
+type LogConfig struct {
+	Level string `json:"level" yaml:"level" env:"LOG_LEVEL" flag:"log-level" validate:"oneof=debug info warn error"`
+}
+
+// appConfig is the configuration the process started with; reloadable
+// settings may since have changed (see reload.go)
+var appConfig *Config
+
+// reloadConfig re-runs the last LoadConfig (same file path and flags)
+var reloadConfig func() (*Config, error)
+
+// defaultConfig returns the built-in development defaults
+func defaultConfig() *Config {
+	return &Config{
+		Environment: "development",
+		Server: ServerConfig{
+			Addr:                  ":8443",
+			CertFile:              filepath.Join("certs", "server.crt"),
+			KeyFile:               filepath.Join("certs", "server.key"),
+			ReadTimeout:           Duration(5 * time.Second),
+			WriteTimeout:          Duration(10 * time.Second),
+			IdleTimeout:           Duration(60 * time.Second),
+			ShutdownTimeout:       Duration(5 * time.Second),
+			RequestTimeout:        Duration(30 * time.Second),
+			MaxConcurrentRequests: 1000,
+		},
+		Database: DatabaseConfig{
+			MaxOpenConns: 10,
+			MaxIdleConns: 5,
+			UserCacheTTL: Duration(5 * time.Minute),
+		},
+		Storage: StorageConfig{
+			Backend: "redis",
+		},
+		Redis: RedisConfig{
+			Mode:         "standalone",
+			Addr:         "localhost:6379",
+			DialTimeout:  Duration(5 * time.Second),
+			ReadTimeout:  Duration(3 * time.Second),
+			WriteTimeout: Duration(3 * time.Second),
+			PoolSize:     20,
+			MinIdleConns: 2,
+		},
+		Keystore: KeystoreConfig{
+			Backend: "kv",
+		},
+		Cache: CacheConfig{
+			StatsTTL:      Duration(time.Hour),
+			NegativeTTL:   Duration(30 * time.Second),
+			JitterPercent: 10,
+		},
+		Retention: RetentionConfig{
+			Default:       retentionForever,
+			Interval:      Duration(24 * time.Hour),
+			RestoreWindow: Duration(7 * 24 * time.Hour),
+			SweepInterval: Duration(time.Hour),
+		},
+		Import: ImportConfig{
+			MaxBytes: 2 * 1024 * 1024, // 2MB
+			MaxRows:  5000,
+		},
+		Export: ExportConfig{
+			AsyncThreshold: 2000,
+			LinkTTL:        Duration(time.Hour),
+		},
+		Security: SecuritySection{
+			AllowedOrigins:       []string{"https://localhost:8443"},
+			RequireHTTPS:         true,
+			RedirectHost:         DefaultRedirectHost,
+			RedirectAllowedHosts: []string{"localhost", DefaultRedirectHost, "127.0.0.1", "127.0.0.1:8443", "[::1]", "[::1]:8443"},
+			MaxRequestBodySize:   10 * 1024 * 1024, // 10MB
+			CSRFTokenExpiry:      Duration(15 * time.Minute),
+			CSRFStore:            "memory",
+			SignatureMaxSkew:     Duration(5 * time.Minute),
+			SecretReloadInterval: Duration(30 * time.Second),
+			EncryptionKeyVersion: "v1",
+		},
+		RateLimit: RateLimitConfig{
+			RequestsPerMinute:     60,
+			AuthRequestsPerMinute: 10,
+		},
+		Log: LogConfig{
+			Level: "info",
+		},
+	}
+}
+
+// LoadConfig builds the configuration for a command. It registers --config,
+// --print-config and every `flag`-tagged field on fs, then parses args.
+// With --print-config the redacted result is printed and the process exits.
+func LoadConfig(fs *flag.FlagSet, args []string) (*Config, error) {
+	configPath := fs.String("config", os.Getenv("CONFIG_FILE"), "path to YAML or JSON config file (env CONFIG_FILE)")
+	printConfig := fs.Bool("print-config", false, "print the effective configuration (secrets redacted) and exit")
+
+	flagValues := make(map[string]string)
+	walkConfig(reflect.ValueOf(defaultConfig()).Elem(), func(field reflect.StructField, _ reflect.Value) {
+		name := field.Tag.Get("flag")
+		if name == "" {
+			return
+		}
+		fs.Func(name, fmt.Sprintf("override %s (env %s)", field.Tag.Get("json"), field.Tag.Get("env")), func(s string) error {
+			flagValues[name] = s
+			return nil
+		})
+	})
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	reloadConfig = func() (*Config, error) {
+		return buildConfig(*configPath, flagValues)
+	}
+	cfg, err := reloadConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	if *printConfig {
+		out, _ := json.MarshalIndent(redactedConfig(cfg), "", "  ")
+		fmt.Println(string(out))
+		os.Exit(0)
+	}
+	return cfg, nil
+}
+
+// buildConfig layers defaults, the file, environment and flag values, then validates
+func buildConfig(path string, flagValues map[string]string) (*Config, error) {
+	cfg := defaultConfig()
+	if path != "" {
+		if err := loadConfigFile(path, cfg); err != nil {
+			return nil, fmt.Errorf("config file %s: %w", path, err)
+		}
+	}
+	if err := applyConfigOverrides(cfg, flagValues); err != nil {
+		return nil, err
+	}
+	if err := validate.Struct(cfg); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+	return cfg, nil
+}
+
+// loadConfigFile decodes a YAML (.yaml/.yml) or JSON (.json) file over cfg
+func loadConfigFile(path string, cfg *Config) error {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(strings.NewReader(string(raw)))
+		dec.KnownFields(true)
+		return dec.Decode(cfg)
+	case ".json":
+		dec := json.NewDecoder(strings.NewReader(string(raw)))
+		dec.DisallowUnknownFields()
+		return dec.Decode(cfg)
+	default:
+		return fmt.Errorf("unsupported config format %q (use .yaml, .yml or .json)", filepath.Ext(path))
+	}
+}
+
+// applyConfigOverrides applies environment variables, then flags
+func applyConfigOverrides(cfg *Config, flagValues map[string]string) error {
+	var errs []string
+	walkConfig(reflect.ValueOf(cfg).Elem(), func(field reflect.StructField, value reflect.Value) {
+		if env := field.Tag.Get("env"); env != "" {
+			var raw string
+			var ok bool
+			if field.Tag.Get("secret") == "true" {
+				raw, _, ok = secretProvider.Lookup(env)
+			} else {
+				raw, ok = os.LookupEnv(env)
+				ok = ok && raw != ""
+			}
+			if ok {
+				if err := setConfigValue(value, raw); err != nil {
+					errs = append(errs, fmt.Sprintf("%s: %v", env, err))
+				}
+			}
+		}
+		if name := field.Tag.Get("flag"); name != "" {
+			if raw, ok := flagValues[name]; ok {
+				if err := setConfigValue(value, raw); err != nil {
+					errs = append(errs, fmt.Sprintf("--%s: %v", name, err))
+				}
+			}
+		}
+	})
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration override: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// walkConfig calls fn for every leaf field of a config struct
+func walkConfig(v reflect.Value, fn func(reflect.StructField, reflect.Value)) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field, value := t.Field(i), v.Field(i)
+		if field.Type.Kind() == reflect.Struct {
+			walkConfig(value, fn)
+			continue
+		}
+		fn(field, value)
+	}
+}
+
+var durationType = reflect.TypeOf(Duration(0))
+
+// setConfigValue parses raw into a config field
+func setConfigValue(v reflect.Value, raw string) error {
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Slice:
+		var items []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	case reflect.Map:
+		// key=value pairs, comma separated
+		items := make(map[string]string)
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item == "" {
+				continue
+			}
+			key, value, ok := strings.Cut(item, "=")
+			if !ok {
+				return fmt.Errorf("expected key=value, got %q", item)
+			}
+			items[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported config type %s", v.Type())
+	}
+	return nil
+}
+
+// redactedConfig returns a copy with `secret` fields masked
+func redactedConfig(cfg *Config) *Config {
+	cp := *cfg
+	cp.Security.AllowedOrigins = append([]string(nil), cfg.Security.AllowedOrigins...)
+	cp.Security.RedirectAllowedHosts = append([]string(nil), cfg.Security.RedirectAllowedHosts...)
+	walkConfig(reflect.ValueOf(&cp).Elem(), func(field reflect.StructField, value reflect.Value) {
+		if field.Tag.Get("secret") == "true" && value.Kind() == reflect.String && value.String() != "" {
+			value.SetString("[REDACTED]")
+		}
+	})
+	return &cp
+}
+
+// ----------------------------------------------------------------------------
+// Duration: time.Duration written as "30s" in YAML/JSON
+// ----------------------------------------------------------------------------
+
+// Duration is a time.Duration that (un)marshals as a Go duration string
+type Duration time.Duration
+
+// D returns the value as time.Duration
+func (d Duration) D() time.Duration {
+	return time.Duration(d)
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"30s\": %w", err)
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+func (d Duration) MarshalYAML() (interface{}, error) {
+	return time.Duration(d).String(), nil
+}
+
+func (d *Duration) UnmarshalYAML(node *yaml.Node) error {
+	parsed, err := time.ParseDuration(node.Value)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// ============================================================================
+// CSRF Token Store (INTEGRITY + AVAILABILITY)
+// ============================================================================
+
+// CSRFStore keeps issued CSRF tokens until they are used or expire.
+// Consume must be atomic: a token can be redeemed at most once.
+type CSRFStore interface {
+	Save(ctx context.Context, token string, expiresAt time.Time) error
+	Consume(ctx context.Context, token string) (bool, error)
+}
+
+var csrfStore CSRFStore
+
+// initCSRFStore selects the backend from config: "memory" (this process) or
+// "redis" (the shared KV store, see kv_store.go). Must run after initStorage.
+func initCSRFStore() {
+	switch securityConfig.CSRFStore {
+	case "redis":
+		csrfStore = &kvCSRFStore{store: kv}
+	default:
+		csrfStore = newMemoryCSRFStore(time.Minute)
+	}
+	infof("[SECURITY] CSRF token store: %s", securityConfig.CSRFStore)
+}
+
+// ----------------------------------------------------------------------------
+// In-memory store (single replica)
+// ----------------------------------------------------------------------------
+
+type memoryCSRFStore struct {
+	mu     sync.Mutex
+	tokens map[string]time.Time
+	done   chan struct{}
+}
+
+// newMemoryCSRFStore creates the store and starts a background sweeper that
+// drops expired tokens every interval, so the map cannot grow without bound.
+func newMemoryCSRFStore(interval time.Duration) *memoryCSRFStore {
+	s := &memoryCSRFStore{
+		tokens: make(map[string]time.Time),
+		done:   make(chan struct{}),
+	}
+	go s.sweep(interval)
+	return s
+}
+
+func (s *memoryCSRFStore) Save(_ context.Context, token string, expiresAt time.Time) error {
+	s.mu.Lock()
+	s.tokens[token] = expiresAt
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *memoryCSRFStore) Consume(_ context.Context, token string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	expiry, exists := s.tokens[token]
+	if !exists {
+		return false, nil
+	}
+	delete(s.tokens, token) // One-time use (expired tokens cleaned up too)
+	return time.Now().Before(expiry), nil
+}
+
+// Close stops the background sweeper
+func (s *memoryCSRFStore) Close() {
+	close(s.done)
+}
+
+func (s *memoryCSRFStore) sweep(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case now := <-ticker.C:
+			s.mu.Lock()
+			for token, expiry := range s.tokens {
+				if !now.Before(expiry) {
+					delete(s.tokens, token)
+				}
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+// ----------------------------------------------------------------------------
+// KV store (shared between replicas when it is Redis)
+// ----------------------------------------------------------------------------
+
+type kvCSRFStore struct {
+	store KVStore
+}
+
+// csrfKey hashes the token so a Redis dump does not contain usable tokens
+func csrfKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return "csrf:" + hex.EncodeToString(sum[:])
+}
+
+func (s *kvCSRFStore) Save(ctx context.Context, token string, expiresAt time.Time) error {
+	return s.store.Set(ctx, csrfKey(token), []byte("1"), time.Until(expiresAt))
+}
+
+// Consume uses GETDEL so two replicas cannot redeem the same token
+func (s *kvCSRFStore) Consume(ctx context.Context, token string) (bool, error) {
+	_, err := s.store.GetDel(ctx, csrfKey(token))
+	if err == errKeyNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil // the store's TTL already removed expired tokens
+}
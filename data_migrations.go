@@ -0,0 +1,471 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ============================================================================
+// Data migrations (migrate up/down/status; schema: schema_migrations.go)
+// ============================================================================
+
+// legacyUser is the pre-blind-index record stored under user:<email>.
+// Password was tagged json:"-" in most versions, so most legacy records
+// carry no hash; those that do keep it.
+type legacyUser struct {
+	ID           string    `json:"id"`
+	Email        string    `json:"email"`
+	Password     string    `json:"password"`
+	PasswordHash string    `json:"password_hash"`
+	FullName     string    `json:"full_name"`
+	Active       bool      `json:"active"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// passwordHash returns the record's bcrypt hash, or "" if it has none
+func (l *legacyUser) passwordHash() string {
+	for _, h := range []string{l.PasswordHash, l.Password} {
+		if _, err := bcrypt.Cost([]byte(h)); err == nil {
+			return h
+		}
+	}
+	return ""
+}
+
+// Data migrations (`server migrate up|down|status`). Each one is idempotent:
+// re-running continues with what is left, and status counts what is pending.
+
+// dataMigration converts stored data; with dryRun it only counts pending items
+type dataMigration struct {
+	name string
+	run  func(ctx context.Context, batch int64, dryRun bool) (migrated, failed int)
+}
+
+var dataMigrations = []dataMigration{
+	{"users-by-id", migrateLegacyUsers},               // user:<email> -> user:<id> + blind index
+	{"users-to-postgres", migrateRedisUsersToStore},   // Redis user:<id> -> PostgreSQL users
+	{"health-to-postgres", migrateRedisHealthToStore}, // Redis health:<uid>:<id> -> PostgreSQL health_records
+	{"health-no-expiry", persistKVHealthRecords},      // drop the former 30-day TTL (retention.go purges instead)
+	{"audit-to-postgres", migrateKVAuditToStore},      // Redis audit:<uid> -> PostgreSQL audit_log
+}
+
+// runMigrateUp implements `server migrate up [--dry-run] [--batch N]`:
+// pending SQL schema migrations first, then the data migrations.
+func runMigrateUp(args []string) {
+	fs := flag.NewFlagSet("migrate up", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "report what would be migrated without writing")
+	batch := fs.Int64("batch", 500, "keys per SCAN batch")
+	cfg := loadCommandConfig(fs, args)
+	cfg.Database.AutoMigrate = false // applied (or not, with --dry-run) below
+
+	ctx, db := initCommand(cfg)
+	if db != nil {
+		applied, err := migrateSchemaUp(ctx, db, *dryRun)
+		if err != nil {
+			log.Fatalf("[MIGRATE] Schema migration failed: %v", err)
+		}
+		if len(applied) == 0 {
+			log.Println("[MIGRATE] Schema is up to date")
+		}
+	} else {
+		log.Println("[MIGRATE] DATABASE_URL not set; skipping schema migrations")
+	}
+
+	totalFailed := 0
+	for _, m := range dataMigrations {
+		migrated, failed := m.run(ctx, *batch, *dryRun)
+		if *dryRun {
+			log.Printf("[MIGRATE] Dry run: %s would migrate %d record(s)", m.name, migrated)
+			continue
+		}
+		log.Printf("[MIGRATE] %s done: migrated=%d failed=%d", m.name, migrated, failed)
+		totalFailed += failed
+	}
+	if totalFailed > 0 {
+		log.Fatal("[MIGRATE] Some records failed; re-run after fixing the errors above")
+	}
+}
+
+// runMigrateStatus implements `server migrate status`
+func runMigrateStatus(args []string) {
+	fs := flag.NewFlagSet("migrate status", flag.ExitOnError)
+	batch := fs.Int64("batch", 500, "keys per SCAN batch")
+	cfg := loadCommandConfig(fs, args)
+	cfg.Database.AutoMigrate = false
+
+	ctx, db := initCommand(cfg)
+	if db != nil {
+		states, err := schemaMigrationStatus(ctx, db)
+		if err != nil {
+			log.Fatalf("[MIGRATE] Cannot read schema status: %v", err)
+		}
+		for _, s := range states {
+			state := "pending"
+			if s.AppliedAt != nil {
+				state = "applied " + s.AppliedAt.UTC().Format(time.RFC3339)
+			}
+			fmt.Printf("%-24s %s\n", s.Migration, state)
+		}
+	}
+	for _, m := range dataMigrations {
+		pending, _ := m.run(ctx, *batch, true)
+		state := "applied"
+		if pending > 0 {
+			state = fmt.Sprintf("pending (%d record(s))", pending)
+		}
+		fmt.Printf("%-24s %s\n", m.name, state)
+	}
+}
+
+// runMigrateDown implements `server migrate down [--steps N] [--dry-run]`.
+// Only SQL schema migrations are reverted: the data migrations drop their
+// source keys, so they cannot be.
+func runMigrateDown(args []string) {
+	fs := flag.NewFlagSet("migrate down", flag.ExitOnError)
+	steps := fs.Int("steps", 1, "number of schema migrations to revert")
+	dryRun := fs.Bool("dry-run", false, "list what would be reverted without writing")
+	cfg := loadCommandConfig(fs, args)
+	cfg.Database.AutoMigrate = false
+
+	if cfg.Database.DSN == "" {
+		log.Fatal("[MIGRATE] DATABASE_URL is required to revert schema migrations")
+	}
+	if *steps < 1 {
+		log.Fatal("[MIGRATE] --steps must be at least 1")
+	}
+	ctx, db := initCommand(cfg)
+	reverted, err := migrateSchemaDown(ctx, db, *steps, *dryRun)
+	if err != nil {
+		log.Fatalf("[MIGRATE] Schema rollback failed: %v", err)
+	}
+	if len(reverted) == 0 {
+		log.Println("[MIGRATE] No applied schema migrations to revert")
+	}
+	log.Println("[MIGRATE] Data migrations are irreversible (source keys are deleted); restore from backup if needed")
+}
+
+// migrateLegacyUsers SCANs user:*@* and converts each record (or only counts
+// them). Users migrated without a password hash cannot log in until an
+// operator sets one (`server user set-password`); their number is reported.
+func migrateLegacyUsers(ctx context.Context, batch int64, dryRun bool) (migrated, failed int) {
+	var cursor uint64
+	needReset := 0
+	for {
+		// '@' only appears in legacy keys; IDs, blind indexes and DEK keys never contain it
+		keys, next, err := kv.Scan(ctx, cursor, "user:*@*", batch)
+		if err != nil {
+			log.Fatalf("[MIGRATE] SCAN failed: %v", err)
+		}
+		for _, key := range keys {
+			if dryRun {
+				migrated++
+				continue
+			}
+			withoutPassword, err := migrateLegacyUser(ctx, key)
+			if err != nil {
+				failed++
+				log.Printf("[MIGRATE] %s: %v", legacyKeyLabel(key), err)
+				continue
+			}
+			if withoutPassword {
+				needReset++
+			}
+			migrated++
+		}
+		if next == 0 {
+			if needReset > 0 {
+				log.Printf("[MIGRATE] %d migrated user(s) have no password and need a reset (server user set-password)", needReset)
+			}
+			return migrated, failed
+		}
+		cursor = next
+	}
+}
+
+// migrateLegacyUser moves one user:<email> record into the user store and
+// reports whether it was created without a password hash. If the email is
+// already registered there, the legacy key is just dropped.
+func migrateLegacyUser(ctx context.Context, key string) (withoutPassword bool, err error) {
+	raw, err := kv.Get(ctx, key)
+	if err == errKeyNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	var legacy legacyUser
+	if err := json.Unmarshal(raw, &legacy); err != nil {
+		return false, err
+	}
+	if legacy.ID == "" {
+		return false, errUserNotFound
+	}
+	if legacy.Email == "" {
+		legacy.Email = strings.TrimPrefix(key, "user:")
+	}
+
+	hash := legacy.passwordHash()
+	err = userStore.Create(ctx, &User{
+		ID:        legacy.ID,
+		Email:     legacy.Email,
+		Password:  hash,
+		FullName:  legacy.FullName,
+		Role:      roleUser,
+		Active:    legacy.Active,
+		CreatedAt: legacy.CreatedAt,
+		UpdatedAt: legacy.UpdatedAt,
+	})
+	if err != nil && err != errEmailTaken {
+		return false, err
+	}
+	withoutPassword = err == nil && hash == ""
+	if withoutPassword {
+		log.Printf("[MIGRATE] User %s has no password hash; it needs a reset before logging in", legacy.ID)
+	}
+	_, err = kv.Del(ctx, key)
+	return withoutPassword, err
+}
+
+// migrateRedisUsersToStore copies Redis-primary user:<id> records into
+// PostgreSQL (no-op without a database). Copied keys are deleted; the read
+// cache refills on demand.
+func migrateRedisUsersToStore(ctx context.Context, batch int64, dryRun bool) (migrated, failed int) {
+	pg := postgresUsers()
+	if pg == nil {
+		return 0, 0
+	}
+	var cursor uint64
+	for {
+		keys, next, err := kv.Scan(ctx, cursor, "user:*", batch)
+		if err != nil {
+			log.Fatalf("[MIGRATE] SCAN failed: %v", err)
+		}
+		for _, key := range keys {
+			parts := strings.Split(key, ":")
+			if len(parts) != 2 || strings.Contains(key, "@") {
+				continue // blind index, DEK or legacy key
+			}
+			raw, err := kv.Get(ctx, key)
+			if err != nil {
+				continue // expired meanwhile
+			}
+			var stored storedUser
+			if err := json.Unmarshal(raw, &stored); err != nil {
+				failed++
+				log.Printf("[MIGRATE] %s: %v", key, err)
+				continue
+			}
+			exists, err := pg.exists(ctx, stored.ID)
+			if err != nil {
+				failed++
+				log.Printf("[MIGRATE] %s: %v", key, err)
+				continue
+			}
+			if exists {
+				continue // cache entry for a user already in PostgreSQL
+			}
+			if dryRun {
+				migrated++
+				continue
+			}
+			if err := pg.insertStored(ctx, &stored); err != nil {
+				failed++
+				log.Printf("[MIGRATE] %s: %v", key, err)
+				continue
+			}
+			kv.Del(ctx, key, userIndexKey(stored.EmailIndex))
+			migrated++
+		}
+		if next == 0 {
+			return migrated, failed
+		}
+		cursor = next
+	}
+}
+
+// migrateRedisHealthToStore copies Redis health records into PostgreSQL (no-op without a database). Records are
+// copied as stored (still encrypted); copied keys are deleted.
+func migrateRedisHealthToStore(ctx context.Context, batch int64, dryRun bool) (migrated, failed int) {
+	pg, ok := healthStore.(*postgresHealthStore)
+	if !ok {
+		return 0, 0
+	}
+	var cursor uint64
+	for {
+		keys, next, err := kv.Scan(ctx, cursor, "health:*", batch)
+		if err != nil {
+			log.Fatalf("[MIGRATE] SCAN failed: %v", err)
+		}
+		for _, key := range keys {
+			parts := strings.Split(key, ":")
+			if len(parts) != 3 || parts[2] == "list" {
+				continue // list index or stats cache
+			}
+			if dryRun {
+				migrated++
+				continue
+			}
+			raw, err := kv.Get(ctx, key)
+			if err != nil {
+				continue // expired meanwhile
+			}
+			var stored storedHealthRecord
+			if err := json.Unmarshal(raw, &stored); err != nil {
+				failed++
+				log.Printf("[MIGRATE] %s: %v", key, err)
+				continue
+			}
+			if err := pg.insertStored(ctx, &stored); err != nil {
+				failed++
+				log.Printf("[MIGRATE] %s: %v", key, err)
+				continue
+			}
+			if err := migrateKVHealthHistory(ctx, pg, &stored); err != nil {
+				failed++
+				log.Printf("[MIGRATE] %s history: %v", key, err)
+				continue
+			}
+			kv.Del(ctx, key, healthHistoryKey(stored.UserID, stored.ID))
+			kv.LRem(ctx, healthListKey(stored.UserID), stored.ID)
+			migrated++
+		}
+		if next == 0 {
+			return migrated, failed
+		}
+		cursor = next
+	}
+}
+
+// migrateKVHealthHistory copies a record's previous versions into
+// health_record_revisions
+func migrateKVHealthHistory(ctx context.Context, pg *postgresHealthStore, record *storedHealthRecord) error {
+	previous, err := kv.LRange(ctx, healthHistoryKey(record.UserID, record.ID))
+	if err != nil {
+		return err
+	}
+	for _, raw := range previous {
+		var stored storedHealthRecord
+		if err := json.Unmarshal([]byte(raw), &stored); err != nil {
+			return err
+		}
+		if err := insertRevision(ctx, pg.db, &stored, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// persistKVHealthRecords removes the 30-day TTL that health:* keys were
+// written with, so retention rules and legal holds decide what is deleted
+// (no-op with a database: the records were moved by health-to-postgres)
+func persistKVHealthRecords(ctx context.Context, batch int64, dryRun bool) (migrated, failed int) {
+	if _, ok := healthStore.(kvHealthStore); !ok {
+		return 0, 0
+	}
+	var cursor uint64
+	for {
+		keys, next, err := kv.Scan(ctx, cursor, "health:*", batch)
+		if err != nil {
+			log.Fatalf("[MIGRATE] SCAN failed: %v", err)
+		}
+		for _, key := range keys {
+			if dryRun {
+				if ttl, err := kv.TTL(ctx, key); err == nil && ttl > 0 {
+					migrated++
+				}
+				continue
+			}
+			persisted, err := kv.Persist(ctx, key)
+			if err != nil {
+				failed++
+				log.Printf("[MIGRATE] %s: %v", key, err)
+				continue
+			}
+			if persisted {
+				migrated++
+			}
+		}
+		if next == 0 {
+			return migrated, failed
+		}
+		cursor = next
+	}
+}
+
+// migrateKVAuditToStore copies per-user audit trails into PostgreSQL (no-op
+// without a database), one transaction per user; copied keys are deleted.
+// Entries already in the table are not copied again, so a re-run after a
+// failed delete adds no duplicates.
+func migrateKVAuditToStore(ctx context.Context, batch int64, dryRun bool) (migrated, failed int) {
+	pg, ok := auditStore.(postgresAuditStore)
+	if !ok {
+		return 0, 0
+	}
+	var cursor uint64
+	for {
+		keys, next, err := kv.Scan(ctx, cursor, "audit:*", batch)
+		if err != nil {
+			log.Fatalf("[MIGRATE] SCAN failed: %v", err)
+		}
+		for _, key := range keys {
+			if dryRun {
+				migrated++
+				continue
+			}
+			userID := strings.TrimPrefix(key, "audit:")
+			if err := copyKVAuditTrail(ctx, pg, userID); err != nil {
+				failed++
+				log.Printf("[MIGRATE] %s: %v", key, err)
+				continue
+			}
+			if _, err := kv.Del(ctx, key); err != nil {
+				log.Printf("[MIGRATE] %s copied but not deleted (re-run to retry): %v", key, err)
+			}
+			migrated++
+		}
+		if next == 0 {
+			return migrated, failed
+		}
+		cursor = next
+	}
+}
+
+func copyKVAuditTrail(ctx context.Context, pg postgresAuditStore, userID string) error {
+	entries, err := kvAuditStore{}.List(ctx, userID)
+	if err != nil {
+		return err
+	}
+	tx, err := pg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, entry := range entries {
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO audit_log (user_id, action, detail, created_at)
+			 SELECT $1::text, $2::text, $3::text, $4::timestamptz
+			  WHERE NOT EXISTS (SELECT 1 FROM audit_log
+			                     WHERE user_id = $1 AND action = $2 AND detail = $3 AND created_at = $4)`,
+			userID, entry.Action, entry.Detail, entry.At); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// legacyKeyLabel avoids logging full email addresses
+func legacyKeyLabel(key string) string {
+	local, domain, ok := strings.Cut(strings.TrimPrefix(key, "user:"), "@")
+	if !ok || local == "" {
+		return "user:<invalid>"
+	}
+	return "user:" + local[:1] + "***@" + domain
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"log"
 
@@ -9,9 +10,10 @@ import (
 
 // openDB mencoba koneksi DB jika dsn tidak kosong.
 // jika dsn kosong -> kembalikan nil (tidak fatal).
-func openDB(dsn string) *sql.DB {
+func openDB(cfg DatabaseConfig) *sql.DB {
+	dsn := cfg.DSN
 	if dsn == "" {
-		log.Println("[DB] DSN kosong, melewatkan koneksi DB")
+		infof("[DB] DSN kosong, melewatkan koneksi DB")
 		return nil
 	}
 	db, err := sql.Open("postgres", dsn)
@@ -19,7 +21,23 @@ func openDB(dsn string) *sql.DB {
 		log.Fatalf("Gagal koneksi DB: %v", err)
 	}
 	// konfigurasi pool
-	db.SetMaxOpenConns(10)
-	db.SetMaxIdleConns(5)
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+
+	// skema: terapkan migrasi tertunda, atau hanya peringatkan
+	ctx := context.Background()
+	if cfg.AutoMigrate {
+		if _, err := migrateSchemaUp(ctx, db, false); err != nil {
+			log.Fatalf("[MIGRATE] Auto-migrate gagal: %v", err)
+		}
+	} else if states, err := schemaMigrationStatus(ctx, db); err != nil {
+		log.Printf("[MIGRATE WARNING] Cannot check schema version: %v", err)
+	} else {
+		for _, s := range states {
+			if s.AppliedAt == nil {
+				log.Printf("[MIGRATE WARNING] Schema migration %s pending; run `server migrate up` or set DB_AUTO_MIGRATE=true", s.Migration)
+			}
+		}
+	}
 	return db
 }
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// ============================================================================
+// CONFIDENTIALITY: AES-256-GCM Encryption at Rest
+// ============================================================================
+
+// Ciphertext format: "enc:<key version>:<base64(nonce || ciphertext || tag)>"
+// Values without the prefix are treated as legacy plaintext on read.
+const ciphertextPrefix = "enc:"
+
+var (
+	keyProvider KeyProvider // nil when no master key is configured
+
+	errUnknownKeyVersion = errors.New("unknown encryption key version")
+	errMalformedCipher   = errors.New("malformed ciphertext")
+)
+
+// initEncryption selects the master key provider: KEYRING_FILE if set,
+// otherwise ENCRYPTION_KEY (plus ENCRYPTION_OLD_KEYS during rotation).
+func initEncryption() {
+	switch {
+	case securityConfig.KeyringFile != "":
+		p, err := newFileKeyProvider(securityConfig.KeyringFile)
+		if err != nil {
+			log.Fatalf("[SECURITY] Invalid KEYRING_FILE: %v", err)
+		}
+		keyProvider = p
+	case securityConfig.EncryptionKey != "":
+		p, err := newEnvKeyProvider(securityConfig.EncryptionKeyVersion, securityConfig.EncryptionKey, securityConfig.EncryptionOldKeys)
+		if err != nil {
+			log.Fatalf("[SECURITY] Invalid ENCRYPTION_KEY/ENCRYPTION_OLD_KEYS: %v", err)
+		}
+		keyProvider = p
+	default:
+		log.Println("[SECURITY WARNING] ENCRYPTION_KEY not set; health data stored unencrypted")
+	}
+}
+
+// parseEncryptionKey accepts a 32-byte key encoded as base64 or hex
+func parseEncryptionKey(encoded string) ([]byte, error) {
+	if key, err := base64.StdEncoding.DecodeString(encoded); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	if key, err := hex.DecodeString(encoded); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	return nil, errors.New("must be 32 bytes encoded as base64 or hex (generate with: openssl rand -base64 32)")
+}
+
+// sealWithKey encrypts with AES-256-GCM; output is nonce || ciphertext || tag
+func sealWithKey(key, plaintext, aad []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+// openWithKey reverses sealWithKey
+func openWithKey(key, sealed, aad []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, errMalformedCipher
+	}
+	nonce, body := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, body, aad)
+}
+
+// EncryptSensitiveData encrypts a string under the current master key.
+// Returns the input unchanged when encryption is not configured.
+// User-owned data should use encryptForUser (envelope.go) instead.
+func EncryptSensitiveData(plaintext string) (string, error) {
+	if keyProvider == nil {
+		return plaintext, nil
+	}
+	version, key, err := keyProvider.CurrentKEK()
+	if err != nil {
+		return "", err
+	}
+	sealed, err := sealWithKey(key, []byte(plaintext), nil)
+	if err != nil {
+		return "", err
+	}
+	return ciphertextPrefix + version + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// DecryptSensitiveData decrypts a value produced by EncryptSensitiveData.
+// Values without the "enc:" prefix are returned as-is (legacy plaintext).
+func DecryptSensitiveData(ciphertext string) (string, error) {
+	if !strings.HasPrefix(ciphertext, ciphertextPrefix) {
+		return ciphertext, nil
+	}
+	version, payload, ok := strings.Cut(strings.TrimPrefix(ciphertext, ciphertextPrefix), ":")
+	if !ok {
+		return "", errMalformedCipher
+	}
+	if keyProvider == nil {
+		return "", fmt.Errorf("%w: %s", errUnknownKeyVersion, version)
+	}
+	key, err := keyProvider.KEK(version)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		return "", errMalformedCipher
+	}
+	plain, err := openWithKey(key, sealed, nil)
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
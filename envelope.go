@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ============================================================================
+// CONFIDENTIALITY: Envelope Encryption (per-user data keys) & Crypto-Shredding
+// ============================================================================
+//
+// Each user gets a random 256-bit data-encryption key (DEK). The DEK is stored
+// only in wrapped form ("<kek version>:<base64>") under user:<id>:dek, wrapped
+// by the master KEK from keyProvider, in dekStore. Destroying the wrapped DEK
+// makes the live copies of the user's records undecryptable. Backups only
+// become unreadable too when they hold no copy of the DEK: that needs the
+// separate keystore (keystore.backend=redis), backed up apart from the data
+// and kept for a shorter time; with keystore.backend=kv a backup taken before
+// the erasure still holds both.
+
+// userCiphertextPrefix marks values encrypted with the owner's DEK and bound
+// to the field they belong to (see userAAD). Values with only the legacy
+// prefix are bound to the owner alone; `keys rotate` reseals them. The
+// "v2:" cannot be confused with a legacy value (base64 has no ':').
+const (
+	legacyUserCiphertextPrefix = ciphertextPrefix + reservedKeyVersion + ":"
+	userCiphertextPrefix       = legacyUserCiphertextPrefix + "v2:"
+)
+
+// userAAD is the additional data of a DEK ciphertext: the owner and the
+// field (e.g. "health:<record id>:notes"), so a sealed value cannot be moved
+// to another record or field, even of the same user
+func userAAD(userID, field string) []byte {
+	return []byte(userID + "\x00" + field)
+}
+
+// dekCacheTTL bounds how long a shredded key may survive in another replica's memory
+const dekCacheTTL = 5 * time.Minute
+
+var errKeyShredded = errors.New("user data key not found (erased)")
+
+type cachedDEK struct {
+	key     []byte
+	expires time.Time
+}
+
+var (
+	dekCacheMu sync.Mutex
+	dekCache   = make(map[string]cachedDEK)
+)
+
+// dekStore holds the wrapped DEKs: kv, or the separate keystore (see initKeystore)
+var (
+	dekStore         KVStore
+	separateKeystore bool
+)
+
+func dekKey(userID string) string {
+	return "user:" + userID + ":dek"
+}
+
+// wrapDEK encrypts a DEK under the current KEK, bound to the user ID
+func wrapDEK(userID string, dek []byte) (string, error) {
+	version, kek, err := keyProvider.CurrentKEK()
+	if err != nil {
+		return "", err
+	}
+	sealed, err := sealWithKey(kek, dek, []byte(dekKey(userID)))
+	if err != nil {
+		return "", err
+	}
+	return version + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// unwrapDEK decrypts a stored wrapped DEK with the KEK version it names
+func unwrapDEK(userID, wrapped string) ([]byte, error) {
+	version, payload, ok := strings.Cut(wrapped, ":")
+	if !ok {
+		return nil, errMalformedCipher
+	}
+	kek, err := keyProvider.KEK(version)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, errMalformedCipher
+	}
+	return openWithKey(kek, sealed, []byte(dekKey(userID)))
+}
+
+// userDataKey returns the user's DEK, generating and storing one if create is set
+func userDataKey(ctx context.Context, userID string, create bool) ([]byte, error) {
+	dekCacheMu.Lock()
+	if c, ok := dekCache[userID]; ok && time.Now().Before(c.expires) {
+		dekCacheMu.Unlock()
+		return c.key, nil
+	}
+	dekCacheMu.Unlock()
+
+	raw, err := dekStore.Get(ctx, dekKey(userID))
+	if err == errKeyNotFound && separateKeystore {
+		raw, err = moveLegacyDEK(ctx, userID)
+	}
+	wrapped := string(raw)
+	if err == errKeyNotFound {
+		if !create {
+			return nil, errKeyShredded
+		}
+		if wrapped, err = createUserDataKey(ctx, userID); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	}
+
+	dek, err := unwrapDEK(userID, wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("unwrap data key: %w", err)
+	}
+
+	dekCacheMu.Lock()
+	dekCache[userID] = cachedDEK{key: dek, expires: time.Now().Add(dekCacheTTL)}
+	dekCacheMu.Unlock()
+	return dek, nil
+}
+
+// createUserDataKey stores a new wrapped DEK; if another request won the race,
+// the existing one is returned instead.
+func createUserDataKey(ctx context.Context, userID string) (string, error) {
+	dek := make([]byte, 32)
+	if _, err := rand.Read(dek); err != nil {
+		return "", err
+	}
+	wrapped, err := wrapDEK(userID, dek)
+	if err != nil {
+		return "", err
+	}
+	created, err := dekStore.SetNX(ctx, dekKey(userID), []byte(wrapped), 0)
+	if err != nil {
+		return "", err
+	}
+	if !created {
+		existing, err := dekStore.Get(ctx, dekKey(userID))
+		return string(existing), err
+	}
+	return wrapped, nil
+}
+
+// moveLegacyDEK moves a DEK written to the main store (before the separate
+// keystore was configured) into the keystore
+func moveLegacyDEK(ctx context.Context, userID string) ([]byte, error) {
+	raw, err := kv.Get(ctx, dekKey(userID))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := dekStore.SetNX(ctx, dekKey(userID), raw, 0); err != nil {
+		return nil, err
+	}
+	if _, err := kv.Del(ctx, dekKey(userID)); err != nil {
+		warnf("[STORE] Data key of %s copied to the keystore but not removed from the main store: %v", userID, err)
+	}
+	return dekStore.Get(ctx, dekKey(userID))
+}
+
+// encryptForUser encrypts a field's value with the owner's DEK
+// ("enc:dek:v2:<base64>"). Returns plaintext unchanged when encryption is not
+// configured.
+func encryptForUser(ctx context.Context, userID, field, plaintext string) (string, error) {
+	if keyProvider == nil {
+		return plaintext, nil
+	}
+	dek, err := userDataKey(ctx, userID, true)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := sealWithKey(dek, []byte(plaintext), userAAD(userID, field))
+	if err != nil {
+		return "", err
+	}
+	return userCiphertextPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// decryptForUser decrypts a value from encryptForUser (field must match).
+// Master-key ciphertexts and legacy plaintext are delegated to
+// DecryptSensitiveData.
+func decryptForUser(ctx context.Context, userID, field, ciphertext string) (string, error) {
+	payload, aad := "", userAAD(userID, field)
+	switch {
+	case strings.HasPrefix(ciphertext, userCiphertextPrefix):
+		payload = strings.TrimPrefix(ciphertext, userCiphertextPrefix)
+	case strings.HasPrefix(ciphertext, legacyUserCiphertextPrefix):
+		payload, aad = strings.TrimPrefix(ciphertext, legacyUserCiphertextPrefix), []byte(userID)
+	default:
+		return DecryptSensitiveData(ciphertext)
+	}
+	if keyProvider == nil {
+		return "", fmt.Errorf("%w: %s", errUnknownKeyVersion, reservedKeyVersion)
+	}
+	dek, err := userDataKey(ctx, userID, false)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		return "", errMalformedCipher
+	}
+	plain, err := openWithKey(dek, sealed, aad)
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
+
+// shredUserKey destroys the user's wrapped DEK (crypto-shredding). Live data
+// encrypted with it becomes unreadable; backups only if they hold no copy of
+// the DEK (see the top of this file).
+func shredUserKey(ctx context.Context, userID string) error {
+	dekCacheMu.Lock()
+	delete(dekCache, userID)
+	dekCacheMu.Unlock()
+	if separateKeystore {
+		if _, err := kv.Del(ctx, dekKey(userID)); err != nil { // not yet moved
+			return err
+		}
+	}
+	_, err := dekStore.Del(ctx, dekKey(userID))
+	return err
+}
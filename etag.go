@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ============================================================================
+// INTEGRITY: Optimistic concurrency for health records (ETag / If-Match)
+// ============================================================================
+//
+// A record's version is its ETag ("3"). Writers send it back in If-Match, so
+// an update or delete based on a stale copy (e.g. edited in the app and the
+// portal at once) fails with 412 instead of overwriting the other change.
+// The check runs inside the store's write (row lock or optimistic KV
+// update), not before it. If-None-Match on GET answers 304 when unchanged.
+
+// errPreconditionFailed is returned when If-Match does not match the record
+var errPreconditionFailed = errors.New("record version does not match If-Match")
+
+// recordETag is the strong entity tag of a record version
+func recordETag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// setRecordETag sets the ETag header for rec
+func setRecordETag(w http.ResponseWriter, rec *HealthRecord) {
+	w.Header().Set("ETag", recordETag(rec.Version))
+}
+
+// versionPrecondition turns If-Match into a check of the current version;
+// nil when the header is absent. Weak tags never match (RFC 9110 strong
+// comparison), "*" matches any existing record.
+func versionPrecondition(r *http.Request) func(version int) bool {
+	tags := entityTags(r.Header.Values("If-Match"))
+	if tags == nil {
+		return nil
+	}
+	return func(version int) bool {
+		current := recordETag(version)
+		for _, tag := range tags {
+			if tag == "*" || tag == current {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// notModified reports whether If-None-Match matches etag (weak comparison)
+func notModified(r *http.Request, etag string) bool {
+	for _, tag := range entityTags(r.Header.Values("If-None-Match")) {
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// entityTags splits comma-separated header values into tags
+func entityTags(values []string) []string {
+	var tags []string
+	for _, value := range values {
+		for _, tag := range strings.Split(value, ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				tags = append(tags, tag)
+			}
+		}
+	}
+	return tags
+}
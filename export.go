@@ -0,0 +1,688 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// ============================================================================
+// Personal data export (data portability): JSON, CSV or ZIP
+// ============================================================================
+//
+// GET /api/v1/export returns the caller's profile, health records and audit
+// trail (audit.go). Small exports are written to the response. Above
+// export.async_threshold records (or with ?async=true) a background job
+// loads the data and builds the file instead: the response is 202 with the
+// job's status URL, and the finished file can be downloaded by its owner
+// until export.link_ttl has passed. Stored files are split into chunks, each
+// encrypted with the user's data key, so erasing the account also makes
+// them unreadable.
+
+// Export formats (?format=)
+const (
+	exportFormatJSON = "json"
+	exportFormatCSV  = "csv"
+	exportFormatZIP  = "zip"
+)
+
+// CSV datasets (?dataset= with format=csv; the ZIP bundle has them all)
+const (
+	exportDatasetRecords = "health_records"
+	exportDatasetAudit   = "audit_log"
+	exportDatasetProfile = "profile"
+)
+
+// Export job statuses
+const (
+	exportJobPending = "pending"
+	exportJobDone    = "done"
+	exportJobFailed  = "failed"
+)
+
+// exportChunkSize is the most of a job's file kept in one KV value
+const exportChunkSize = 512 << 10
+
+// exportJob is a background export (export:job:<id>, file in
+// export:file:<id>:<n>, one key per exportChunkSize bytes)
+type exportJob struct {
+	ID          string     `json:"id"`
+	UserID      string     `json:"user_id"`
+	Format      string     `json:"format"`
+	Dataset     string     `json:"dataset,omitempty"`
+	Status      string     `json:"status"`
+	Records     int        `json:"records"`        // known once the data is loaded
+	Size        int        `json:"size,omitempty"` // bytes, when done
+	CreatedAt   time.Time  `json:"created_at"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+	ExpiresAt   time.Time  `json:"expires_at"`             // job and file are removed
+	DownloadURL string     `json:"download_url,omitempty"` // set in responses once done
+	Error       string     `json:"error,omitempty"`
+}
+
+func exportJobKey(id string) string {
+	return "export:job:" + id
+}
+
+func exportFileKey(id string, chunk int) string {
+	return "export:file:" + id + ":" + strconv.Itoa(chunk)
+}
+
+// exportChunkCount is the number of chunks of a size-byte file
+func exportChunkCount(size int) int {
+	return (size + exportChunkSize - 1) / exportChunkSize
+}
+
+// exportRunningKey allows one running job per user
+func exportRunningKey(userID string) string {
+	return "export:running:" + userID
+}
+
+// exportHandler exports the authenticated user's data
+// GET /api/v1/export?format=json|csv|zip[&dataset=...][&async=true] (protected)
+// CONFIDENTIALITY: Owner only; job files encrypted with the user's data key
+// AVAILABILITY: Large exports run in the background
+func exportHandler(cfg ExportConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		// Get user ID from context
+		userID, ok := r.Context().Value("user").(string)
+		if !ok {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Unauthorized"})
+			return
+		}
+
+		query := r.URL.Query()
+		format := query.Get("format")
+		if format == "" {
+			format = exportFormatJSON
+		}
+		dataset := ""
+		switch format {
+		case exportFormatJSON, exportFormatZIP:
+		case exportFormatCSV:
+			dataset = query.Get("dataset")
+			if dataset == "" {
+				dataset = exportDatasetRecords
+			}
+			if dataset != exportDatasetRecords && dataset != exportDatasetAudit && dataset != exportDatasetProfile {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]string{"error": "Invalid 'dataset' parameter"})
+				return
+			}
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Invalid 'format' parameter"})
+			return
+		}
+		async := false
+		if raw := query.Get("async"); raw != "" {
+			var err error
+			if async, err = strconv.ParseBool(raw); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]string{"error": "Invalid 'async' parameter"})
+				return
+			}
+		}
+
+		// At most AsyncThreshold+1 records are read to decide; a job loads
+		// the data itself
+		var records []*HealthRecord
+		var err error
+		if !async {
+			records, err = healthStore.List(r.Context(), userID, HealthRecordQuery{Limit: cfg.AsyncThreshold + 1})
+			async = err == nil && len(records) > cfg.AsyncThreshold
+		}
+		if err == nil && async {
+			startExportJob(w, r, cfg, userID, format, dataset)
+			return
+		}
+		var doc *userExport
+		if err == nil {
+			doc, err = completeUserExport(r.Context(), userID, records)
+		}
+		if err != nil {
+			log.Printf("[EXPORT] Failed to load data of %s: %v", userID, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to export data"})
+			return
+		}
+
+		w.Header().Set("Content-Type", exportContentType(format))
+		w.Header().Set("Content-Disposition", `attachment; filename="`+exportFilename(format, dataset, doc.ExportedAt)+`"`)
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusOK)
+		if err := writeExport(w, format, dataset, doc); err != nil {
+			// Headers are sent; the client sees a truncated file
+			log.Printf("[EXPORT] Streaming export of %s failed: %v", userID, err)
+			return
+		}
+		auditEvent(r.Context(), userID, auditDataExported, "Data exported: %s, %d records for user %s",
+			format, len(doc.HealthRecords), userID)
+	}
+}
+
+// startExportJob answers 202 and builds the export in the background
+func startExportJob(w http.ResponseWriter, r *http.Request, cfg ExportConfig, userID, format, dataset string) {
+	now := time.Now().UTC()
+	job := &exportJob{
+		ID:        uuid.New().String(),
+		UserID:    userID,
+		Format:    format,
+		Dataset:   dataset,
+		Status:    exportJobPending,
+		CreatedAt: now,
+		ExpiresAt: now.Add(cfg.LinkTTL.D()),
+	}
+
+	// One job per user at a time (AVAILABILITY); the lock expires with the job
+	acquired, err := kv.SetNX(r.Context(), exportRunningKey(userID), []byte(job.ID), cfg.LinkTTL.D())
+	if err == nil && !acquired {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]string{"error": "An export is already running"})
+		return
+	}
+	if err == nil {
+		err = saveExportJob(r.Context(), job)
+	}
+	if err != nil {
+		log.Printf("[EXPORT] Failed to start job for %s: %v", userID, err)
+		kv.Del(r.Context(), exportRunningKey(userID))
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to start export"})
+		return
+	}
+
+	accepted := *job // the job goroutine owns job from here
+	go runExportJob(job, cfg.LinkTTL.D())
+
+	auditEvent(r.Context(), userID, auditDataExported, "Data export job started: %s (%s) for user %s",
+		accepted.ID, format, userID)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/api/v1/export/jobs/"+accepted.ID)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(accepted)
+}
+
+// runExportJob loads the data, then builds, encrypts and stores the file;
+// the link is valid for ttl from completion
+func runExportJob(job *exportJob, ttl time.Duration) {
+	ctx := context.Background()
+	defer kv.Del(ctx, exportRunningKey(job.UserID))
+
+	file := &exportFileWriter{ctx: ctx, job: job, ttl: ttl}
+	doc, err := loadUserExport(ctx, job.UserID)
+	if err == nil {
+		job.Records = len(doc.HealthRecords)
+		err = file.open()
+	}
+	if err == nil {
+		err = writeExport(file, job.Format, job.Dataset, doc)
+	}
+	if err == nil {
+		err = file.close()
+	}
+
+	now := time.Now().UTC()
+	job.FinishedAt, job.ExpiresAt = &now, now.Add(ttl)
+	if err != nil {
+		log.Printf("[EXPORT] Job %s failed: %v", job.ID, err)
+		job.Status, job.Error = exportJobFailed, "Export failed"
+		file.discard()
+	} else {
+		job.Status, job.Size = exportJobDone, file.size
+	}
+	if err := saveExportJob(ctx, job); err != nil {
+		log.Printf("[EXPORT] Failed to save job %s: %v", job.ID, err)
+	}
+}
+
+// exportFileWriter encrypts and stores a job's file one exportChunkSize chunk
+// at a time, so no single KV value holds the whole file
+type exportFileWriter struct {
+	ctx    context.Context
+	job    *exportJob
+	ttl    time.Duration
+	dek    []byte // nil when encryption is not configured
+	buf    []byte
+	chunks int
+	size   int
+}
+
+func (f *exportFileWriter) open() error {
+	if keyProvider == nil {
+		return nil
+	}
+	dek, err := userDataKey(f.ctx, f.job.UserID, true)
+	f.dek = dek
+	return err
+}
+
+func (f *exportFileWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		take := min(exportChunkSize-len(f.buf), len(p))
+		f.buf, p = append(f.buf, p[:take]...), p[take:]
+		if len(f.buf) == exportChunkSize {
+			if err := f.flush(); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return n, nil
+}
+
+func (f *exportFileWriter) flush() error {
+	data := f.buf
+	if f.dek != nil {
+		var err error
+		if data, err = sealWithKey(f.dek, f.buf, exportChunkAAD(f.job, f.chunks)); err != nil {
+			return err
+		}
+	}
+	if err := kv.Set(f.ctx, exportFileKey(f.job.ID, f.chunks), data, f.ttl); err != nil {
+		return err
+	}
+	f.chunks++
+	f.size += len(f.buf)
+	f.buf = f.buf[:0]
+	return nil
+}
+
+// close stores the last chunk and restarts every chunk's TTL, so the file
+// expires with the job
+func (f *exportFileWriter) close() error {
+	if len(f.buf) > 0 {
+		if err := f.flush(); err != nil {
+			return err
+		}
+	}
+	for n := 0; n < f.chunks; n++ {
+		if err := kv.Expire(f.ctx, exportFileKey(f.job.ID, n), f.ttl); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// discard removes the chunks of a failed job
+func (f *exportFileWriter) discard() {
+	for n := 0; n < f.chunks; n++ {
+		kv.Del(f.ctx, exportFileKey(f.job.ID, n))
+	}
+}
+
+// exportChunkAAD binds a chunk to its job and position
+func exportChunkAAD(job *exportJob, chunk int) []byte {
+	return []byte("export:" + job.ID + ":" + strconv.Itoa(chunk))
+}
+
+// loadExportChunk reads and decrypts chunk n of a finished job's file
+func loadExportChunk(ctx context.Context, job *exportJob, n int) ([]byte, error) {
+	data, err := kv.Get(ctx, exportFileKey(job.ID, n))
+	if err != nil || keyProvider == nil {
+		return data, err
+	}
+	dek, err := userDataKey(ctx, job.UserID, false)
+	if err != nil {
+		return nil, err
+	}
+	return openWithKey(dek, data, exportChunkAAD(job, n))
+}
+
+// saveExportJob stores the job until it expires
+func saveExportJob(ctx context.Context, job *exportJob) error {
+	raw, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	return kv.Set(ctx, exportJobKey(job.ID), raw, time.Until(job.ExpiresAt))
+}
+
+// loadExportJob returns the caller's job; other users' jobs are not found
+func loadExportJob(ctx context.Context, userID, id string) (*exportJob, error) {
+	raw, err := kv.Get(ctx, exportJobKey(id))
+	if err != nil {
+		return nil, err
+	}
+	var job exportJob
+	if err := json.Unmarshal(raw, &job); err != nil {
+		return nil, err
+	}
+	if job.UserID != userID {
+		return nil, errKeyNotFound
+	}
+	if job.Status == exportJobDone {
+		job.DownloadURL = "/api/v1/export/jobs/" + job.ID + "/download"
+	}
+	return &job, nil
+}
+
+// getExportJobHandler reports a background export
+// GET /api/v1/export/jobs/{id} (protected)
+func getExportJobHandler(w http.ResponseWriter, r *http.Request) {
+	// Get user ID from context
+	userID, ok := r.Context().Value("user").(string)
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Unauthorized"})
+		return
+	}
+
+	job, err := loadExportJob(r.Context(), userID, chi.URLParam(r, "id"))
+	if err == errKeyNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Export not found or expired"})
+		return
+	}
+	if err != nil {
+		log.Printf("[EXPORT] Failed to load job: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to load export"})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(job)
+}
+
+// downloadExportHandler serves a finished background export until it expires
+// GET /api/v1/export/jobs/{id}/download (protected)
+// CONFIDENTIALITY: Owner only, decrypted with the user's data key
+func downloadExportHandler(w http.ResponseWriter, r *http.Request) {
+	// Get user ID from context
+	userID, ok := r.Context().Value("user").(string)
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Unauthorized"})
+		return
+	}
+
+	// The first chunk is read before answering, so an expired or shredded
+	// file is a 404 rather than a truncated download
+	job, err := loadExportJob(r.Context(), userID, chi.URLParam(r, "id"))
+	var first []byte
+	if err == nil && job.Status == exportJobDone && job.Size > 0 {
+		first, err = loadExportChunk(r.Context(), job, 0)
+	}
+	switch {
+	case err == errKeyNotFound || err == errKeyShredded:
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Export not found or expired"})
+		return
+	case err != nil:
+		log.Printf("[EXPORT] Failed to load file of job: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to load export"})
+		return
+	case job.Status == exportJobPending:
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Export is not ready yet"})
+		return
+	case job.Status == exportJobFailed:
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Export failed, request a new one"})
+		return
+	}
+
+	auditEvent(r.Context(), userID, auditDataExported, "Data export downloaded: %s (%s), %d records for user %s",
+		job.ID, job.Format, job.Records, userID)
+
+	w.Header().Set("Content-Type", exportContentType(job.Format))
+	w.Header().Set("Content-Disposition", `attachment; filename="`+exportFilename(job.Format, job.Dataset, job.CreatedAt)+`"`)
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	w.Write(first)
+	for n := 1; n < exportChunkCount(job.Size); n++ {
+		chunk, err := loadExportChunk(r.Context(), job, n)
+		if err != nil {
+			// Headers are sent; the client sees a truncated file
+			log.Printf("[EXPORT] Reading chunk %d of job %s failed: %v", n, job.ID, err)
+			return
+		}
+		w.Write(chunk)
+	}
+}
+
+// loadUserExport reads everything exported for a user (also `export user`)
+func loadUserExport(ctx context.Context, userID string) (*userExport, error) {
+	records, err := healthStore.List(ctx, userID, HealthRecordQuery{})
+	if err != nil {
+		return nil, fmt.Errorf("health records: %w", err)
+	}
+	return completeUserExport(ctx, userID, records)
+}
+
+// completeUserExport adds the profile and audit trail to already loaded records
+func completeUserExport(ctx context.Context, userID string, records []*HealthRecord) (*userExport, error) {
+	user, err := userStore.GetByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	audit, err := auditStore.List(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("audit trail: %w", err)
+	}
+	return &userExport{ExportedAt: time.Now().UTC(), User: user, HealthRecords: records, AuditLog: audit}, nil
+}
+
+func exportContentType(format string) string {
+	switch format {
+	case exportFormatCSV:
+		return "text/csv; charset=utf-8"
+	case exportFormatZIP:
+		return "application/zip"
+	}
+	return "application/json"
+}
+
+// exportFilename is e.g. health-export-2025-10-23.zip or
+// health-export-2025-10-23-audit_log.csv
+func exportFilename(format, dataset string, at time.Time) string {
+	name := "health-export-" + at.Format("2006-01-02")
+	if dataset != "" {
+		name += "-" + dataset
+	}
+	return name + "." + format
+}
+
+// ----------------------------------------------------------------------------
+// Writers (the document is loaded in memory; records are marshaled one at a
+// time rather than as one value)
+// ----------------------------------------------------------------------------
+
+// writeExport writes doc in format (dataset selects the CSV)
+func writeExport(w io.Writer, format, dataset string, doc *userExport) error {
+	switch format {
+	case exportFormatCSV:
+		switch dataset {
+		case exportDatasetAudit:
+			return writeAuditCSV(w, doc.AuditLog)
+		case exportDatasetProfile:
+			return writeProfileCSV(w, doc.User)
+		}
+		return writeHealthRecordsCSV(w, doc.HealthRecords)
+	case exportFormatZIP:
+		return writeExportZIP(w, doc)
+	}
+	return writeExportJSON(w, doc)
+}
+
+// writeExportJSON writes the userExport document element by element
+func writeExportJSON(w io.Writer, doc *userExport) error {
+	bw := bufio.NewWriter(w)
+	exportedAt, _ := json.Marshal(doc.ExportedAt)
+	user, err := json.Marshal(doc.User)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(bw, `{"exported_at":%s,"user":%s,"health_records":`, exportedAt, user)
+	if err := writeJSONArray(bw, doc.HealthRecords); err != nil {
+		return err
+	}
+	bw.WriteString(`,"audit_log":`)
+	if err := writeJSONArray(bw, doc.AuditLog); err != nil {
+		return err
+	}
+	bw.WriteString("}\n")
+	return bw.Flush()
+}
+
+func writeJSONArray[T any](bw *bufio.Writer, items []T) error {
+	bw.WriteByte('[')
+	for i, item := range items {
+		if i > 0 {
+			bw.WriteByte(',')
+		}
+		raw, err := json.Marshal(item)
+		if err != nil {
+			return err
+		}
+		bw.Write(raw)
+	}
+	return bw.WriteByte(']')
+}
+
+// writeHealthRecordsCSV uses the import column names (health_import.go),
+// so an export can be imported again
+func writeHealthRecordsCSV(w io.Writer, records []*HealthRecord) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"id", "type", "value", "unit", "notes", "recorded_at", "created_at", "version", "updated_at"})
+	for _, rec := range records {
+		updatedAt := ""
+		if rec.UpdatedAt != nil {
+			updatedAt = rec.UpdatedAt.Format(time.RFC3339)
+		}
+		cw.Write([]string{
+			rec.ID, rec.Type, strconv.FormatFloat(rec.Value, 'f', -1, 64), rec.Unit, csvText(rec.Notes),
+			rec.RecordedAt.Format(time.RFC3339), rec.CreatedAt.Format(time.RFC3339), strconv.Itoa(rec.Version), updatedAt,
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func writeAuditCSV(w io.Writer, entries []*AuditEntry) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"at", "action", "detail"})
+	for _, entry := range entries {
+		cw.Write([]string{entry.At.Format(time.RFC3339), entry.Action, csvText(entry.Detail)})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func writeProfileCSV(w io.Writer, user *User) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"id", "email", "full_name", "role", "active", "legal_hold", "created_at", "updated_at"})
+	cw.Write([]string{
+		user.ID, csvText(user.Email), csvText(user.FullName), user.Role, strconv.FormatBool(user.Active),
+		strconv.FormatBool(user.LegalHold), user.CreatedAt.Format(time.RFC3339), user.UpdatedAt.Format(time.RFC3339),
+	})
+	cw.Flush()
+	return cw.Error()
+}
+
+// csvText stops spreadsheets from evaluating user text as a formula
+// (CSV injection) by prefixing it with an apostrophe
+func csvText(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// exportManifest describes the files of a ZIP export (manifest.json)
+type exportManifest struct {
+	Format     string               `json:"format"`
+	Version    int                  `json:"version"`
+	ExportedAt time.Time            `json:"exported_at"`
+	UserID     string               `json:"user_id"`
+	Files      []exportManifestFile `json:"files"`
+}
+
+type exportManifestFile struct {
+	Name    string `json:"name"`
+	Records int    `json:"records"`
+	Bytes   int64  `json:"bytes"`
+	SHA256  string `json:"sha256"`
+}
+
+// writeExportZIP writes each dataset as a file, then manifest.json with
+// their sizes and checksums
+func writeExportZIP(w io.Writer, doc *userExport) error {
+	zw := zip.NewWriter(w)
+	manifest := exportManifest{Format: "health-export", Version: 1, ExportedAt: doc.ExportedAt, UserID: doc.User.ID}
+	files := []struct {
+		name    string
+		records int
+		write   func(io.Writer) error
+	}{
+		{"profile.json", 1, func(w io.Writer) error { return writeIndentedJSON(w, doc.User) }},
+		{"health_records.json", len(doc.HealthRecords), func(w io.Writer) error {
+			bw := bufio.NewWriter(w)
+			if err := writeJSONArray(bw, doc.HealthRecords); err != nil {
+				return err
+			}
+			return bw.Flush()
+		}},
+		{"health_records.csv", len(doc.HealthRecords), func(w io.Writer) error { return writeHealthRecordsCSV(w, doc.HealthRecords) }},
+		{"audit_log.csv", len(doc.AuditLog), func(w io.Writer) error { return writeAuditCSV(w, doc.AuditLog) }},
+	}
+	for _, file := range files {
+		fw, err := zw.CreateHeader(&zip.FileHeader{Name: file.name, Method: zip.Deflate, Modified: doc.ExportedAt})
+		if err != nil {
+			return err
+		}
+		hash, size := sha256.New(), &byteCounter{}
+		if err := file.write(io.MultiWriter(fw, hash, size)); err != nil {
+			return err
+		}
+		manifest.Files = append(manifest.Files, exportManifestFile{
+			Name: file.name, Records: file.records, Bytes: size.n, SHA256: hex.EncodeToString(hash.Sum(nil)),
+		})
+	}
+	fw, err := zw.CreateHeader(&zip.FileHeader{Name: "manifest.json", Method: zip.Deflate, Modified: doc.ExportedAt})
+	if err != nil {
+		return err
+	}
+	if err := writeIndentedJSON(fw, manifest); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+func writeIndentedJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// byteCounter counts what is written through it
+type byteCounter struct{ n int64 }
+
+func (c *byteCounter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io"
+	"log"
+	"os"
+	"time"
+)
+
+// ============================================================================
+// Data export command (export user): profile + health records + audit trail, decrypted
+// ============================================================================
+
+// userExport is the export document (data portability / subject access
+// requests); GET /api/v1/export streams the same shape (export.go)
+type userExport struct {
+	ExportedAt    time.Time       `json:"exported_at"`
+	User          *User           `json:"user"`
+	HealthRecords []*HealthRecord `json:"health_records"`
+	AuditLog      []*AuditEntry   `json:"audit_log"`
+}
+
+// runExportUser implements `server export user (--email E | --id ID) [--out file]`.
+// The output contains decrypted health data: write it to a protected location.
+func runExportUser(args []string) {
+	fs := flag.NewFlagSet("export user", flag.ExitOnError)
+	email := fs.String("email", "", "user email")
+	id := fs.String("id", "", "user ID")
+	out := fs.String("out", "", "output file (default stdout)")
+	cfg := loadCommandConfig(fs, args)
+
+	ctx, _ := initCommand(cfg)
+	user, err := lookupUserForCommand(ctx, *id, *email)
+	if err != nil {
+		log.Fatalf("[EXPORT] %v", err)
+	}
+	doc, err := loadUserExport(ctx, user.ID)
+	if err != nil {
+		log.Fatalf("[EXPORT] Failed to load data: %v", err)
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			log.Fatalf("[EXPORT] %v", err)
+		}
+		defer f.Close()
+		w = f
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		log.Fatalf("[EXPORT] %v", err)
+	}
+	auditEvent(ctx, user.ID, auditDataExported, "User data exported via CLI: %s, %d records", user.ID, len(doc.HealthRecords))
+}
@@ -0,0 +1,713 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// ============================================================================
+// HL7 FHIR R4: health records as Observation resources
+// ============================================================================
+//
+// /fhir (also /api/v1/fhir) is a small FHIR R4 (JSON) endpoint for partner
+// EHRs. Each health record is an Observation with a LOINC code and a UCUM
+// quantity; the authenticated user is the Patient (Patient/<user_id>), so a
+// client only ever sees and writes its own compartment.
+//
+// - GET  /fhir/Observation?patient=...  search (searchset Bundle)
+// - GET  /fhir/Observation/{id}         read
+// - POST /fhir/Bundle                   import a transaction (all or
+//   nothing) or batch (per entry) Bundle of Observation creates
+//
+// A record holds one value, so blood pressure records are systolic (8480-6)
+// Observations. A panel (85354-9) is imported only if it has no diastolic
+// component, which could not be stored. Errors are OperationOutcome resources.
+
+const (
+	fhirContentType = "application/fhir+json; charset=utf-8"
+	fhirLOINC       = "http://loinc.org"
+	fhirUCUM        = "http://unitsofmeasure.org"
+	fhirCategories  = "http://terminology.hl7.org/CodeSystem/observation-category"
+
+	fhirBloodPressurePanel = "85354-9"
+	fhirSystolic           = "8480-6"
+	fhirDiastolic          = "8462-4"
+
+	fhirDefaultCount = 100
+	fhirMaxCount     = 1000
+)
+
+// fhirCode is the LOINC code (and Observation category) of a record type
+type fhirCode struct {
+	Code     string
+	Display  string
+	Category string
+}
+
+// fhirCodes maps every type in healthRecordTypes to LOINC
+var fhirCodes = map[string]fhirCode{
+	"heart_rate":     {"8867-4", "Heart rate", "vital-signs"},
+	"weight":         {"29463-7", "Body weight", "vital-signs"},
+	"blood_pressure": {fhirSystolic, "Systolic blood pressure", "vital-signs"},
+	"temperature":    {"8310-5", "Body temperature", "vital-signs"},
+	"glucose":        {"2339-0", "Glucose [Mass/volume] in Blood", "laboratory"},
+}
+
+// fhirUCUMUnits maps the units clients send to UCUM codes. Units not listed
+// are exported as text only (Quantity.unit without a code).
+var fhirUCUMUnits = map[string]string{
+	"bpm":    "/min",
+	"kg":     "kg",
+	"lb":     "[lb_av]",
+	"lbs":    "[lb_av]",
+	"°C":     "Cel",
+	"°F":     "[degF]",
+	"mg/dL":  "mg/dL",
+	"mmol/L": "mmol/L",
+	"mmHg":   "mm[Hg]",
+}
+
+// fhirRecordUnits maps UCUM codes back to the units stored in records
+var fhirRecordUnits = map[string]string{
+	"/min":        "bpm",
+	"{beats}/min": "bpm",
+	"kg":          "kg",
+	"[lb_av]":     "lb",
+	"Cel":         "°C",
+	"[degF]":      "°F",
+	"mg/dL":       "mg/dL",
+	"mmol/L":      "mmol/L",
+	"mm[Hg]":      "mmHg",
+}
+
+// ----------------------------------------------------------------------------
+// Resources (only the elements this server reads or writes)
+// ----------------------------------------------------------------------------
+
+type fhirCoding struct {
+	System  string `json:"system,omitempty"`
+	Code    string `json:"code,omitempty"`
+	Display string `json:"display,omitempty"`
+}
+
+type fhirCodeableConcept struct {
+	Coding []fhirCoding `json:"coding,omitempty"`
+	Text   string       `json:"text,omitempty"`
+}
+
+type fhirQuantity struct {
+	Value  *float64 `json:"value,omitempty"`
+	Unit   string   `json:"unit,omitempty"`
+	System string   `json:"system,omitempty"`
+	Code   string   `json:"code,omitempty"`
+}
+
+type fhirReference struct {
+	Reference string `json:"reference,omitempty"`
+}
+
+type fhirMeta struct {
+	VersionID   string `json:"versionId,omitempty"`
+	LastUpdated string `json:"lastUpdated,omitempty"`
+}
+
+type fhirAnnotation struct {
+	Text string `json:"text"`
+}
+
+type fhirObservationComponent struct {
+	Code          fhirCodeableConcept `json:"code"`
+	ValueQuantity *fhirQuantity       `json:"valueQuantity,omitempty"`
+}
+
+// fhirObservation is an Observation resource
+type fhirObservation struct {
+	ResourceType      string                     `json:"resourceType"`
+	ID                string                     `json:"id,omitempty"`
+	Meta              *fhirMeta                  `json:"meta,omitempty"`
+	Status            string                     `json:"status"`
+	Category          []fhirCodeableConcept      `json:"category,omitempty"`
+	Code              fhirCodeableConcept        `json:"code"`
+	Subject           *fhirReference             `json:"subject,omitempty"`
+	EffectiveDateTime string                     `json:"effectiveDateTime,omitempty"`
+	ValueQuantity     *fhirQuantity              `json:"valueQuantity,omitempty"`
+	Note              []fhirAnnotation           `json:"note,omitempty"`
+	Component         []fhirObservationComponent `json:"component,omitempty"`
+}
+
+type fhirBundleLink struct {
+	Relation string `json:"relation"`
+	URL      string `json:"url"`
+}
+
+type fhirBundleRequest struct {
+	Method string `json:"method"`
+	URL    string `json:"url"`
+}
+
+type fhirBundleResponse struct {
+	Status       string                `json:"status"`
+	Location     string                `json:"location,omitempty"`
+	Etag         string                `json:"etag,omitempty"`
+	LastModified string                `json:"lastModified,omitempty"`
+	Outcome      *fhirOperationOutcome `json:"outcome,omitempty"`
+}
+
+type fhirBundleSearch struct {
+	Mode string `json:"mode"`
+}
+
+type fhirBundleEntry struct {
+	FullURL  string              `json:"fullUrl,omitempty"`
+	Resource json.RawMessage     `json:"resource,omitempty"`
+	Search   *fhirBundleSearch   `json:"search,omitempty"`
+	Request  *fhirBundleRequest  `json:"request,omitempty"`
+	Response *fhirBundleResponse `json:"response,omitempty"`
+}
+
+// fhirBundle is a Bundle resource (searchset, transaction, batch and their responses)
+type fhirBundle struct {
+	ResourceType string            `json:"resourceType"`
+	Type         string            `json:"type"`
+	Timestamp    string            `json:"timestamp,omitempty"`
+	Total        *int              `json:"total,omitempty"`
+	Link         []fhirBundleLink  `json:"link,omitempty"`
+	Entry        []fhirBundleEntry `json:"entry,omitempty"`
+}
+
+type fhirIssue struct {
+	Severity    string   `json:"severity"` // error, warning
+	Code        string   `json:"code"`     // invalid, not-found, forbidden, not-supported, too-costly, exception
+	Diagnostics string   `json:"diagnostics,omitempty"`
+	Expression  []string `json:"expression,omitempty"`
+}
+
+// fhirOperationOutcome reports errors and warnings
+type fhirOperationOutcome struct {
+	ResourceType string      `json:"resourceType"`
+	Issue        []fhirIssue `json:"issue"`
+}
+
+func newOperationOutcome(issues ...fhirIssue) *fhirOperationOutcome {
+	return &fhirOperationOutcome{ResourceType: "OperationOutcome", Issue: issues}
+}
+
+// writeFHIR sends a FHIR resource
+func writeFHIR(w http.ResponseWriter, status int, resource interface{}) {
+	w.Header().Set("Content-Type", fhirContentType)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resource)
+}
+
+// writeFHIRError sends an OperationOutcome with one error
+func writeFHIRError(w http.ResponseWriter, status int, code, diagnostics string) {
+	writeFHIR(w, status, newOperationOutcome(fhirIssue{Severity: "error", Code: code, Diagnostics: diagnostics}))
+}
+
+// fhirBase is the absolute base URL of the FHIR endpoint (for fullUrl)
+func fhirBase(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	base := "/api/v1/fhir/"
+	if strings.HasPrefix(r.URL.Path, "/fhir/") {
+		base = "/fhir/"
+	}
+	return scheme + "://" + r.Host + base
+}
+
+func fhirInstant(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+// ----------------------------------------------------------------------------
+// Mapping
+// ----------------------------------------------------------------------------
+
+// observationFromRecord maps a record to an Observation
+func observationFromRecord(rec *HealthRecord) *fhirObservation {
+	lastUpdated := rec.CreatedAt
+	if rec.UpdatedAt != nil {
+		lastUpdated = *rec.UpdatedAt
+	}
+	obs := &fhirObservation{
+		ResourceType:      "Observation",
+		ID:                rec.ID,
+		Meta:              &fhirMeta{VersionID: strconv.Itoa(max(rec.Version, 1)), LastUpdated: fhirInstant(lastUpdated)},
+		Status:            "final",
+		Subject:           &fhirReference{Reference: "Patient/" + rec.UserID},
+		EffectiveDateTime: fhirInstant(rec.RecordedAt),
+	}
+	if rec.Version > 1 {
+		obs.Status = "amended"
+	}
+	code, ok := fhirCodes[rec.Type]
+	if !ok {
+		code = fhirCode{Display: rec.Type}
+	}
+	obs.Code = fhirCodeableConcept{Text: code.Display}
+	if code.Code != "" {
+		obs.Code.Coding = []fhirCoding{{System: fhirLOINC, Code: code.Code, Display: code.Display}}
+	}
+	if code.Category != "" {
+		obs.Category = []fhirCodeableConcept{{Coding: []fhirCoding{{System: fhirCategories, Code: code.Category}}}}
+	}
+
+	value := rec.Value
+	quantity := &fhirQuantity{Value: &value, Unit: rec.Unit}
+	if ucum, ok := fhirUCUMUnits[rec.Unit]; ok {
+		quantity.System, quantity.Code = fhirUCUM, ucum
+	}
+	obs.ValueQuantity = quantity
+	if rec.Notes != "" {
+		obs.Note = []fhirAnnotation{{Text: rec.Notes}}
+	}
+	return obs
+}
+
+// fhirRecordType finds the record type of a LOINC-coded concept
+func fhirRecordType(concept fhirCodeableConcept) (string, bool) {
+	for _, coding := range concept.Coding {
+		if coding.System != fhirLOINC {
+			continue
+		}
+		for recordType, code := range fhirCodes {
+			if code.Code == coding.Code {
+				return recordType, true
+			}
+		}
+	}
+	return "", false
+}
+
+func fhirHasCode(concept fhirCodeableConcept, code string) bool {
+	return slices.ContainsFunc(concept.Coding, func(c fhirCoding) bool {
+		return c.System == fhirLOINC && c.Code == code
+	})
+}
+
+// recordRequestFromObservation maps an Observation to a create request. The
+// subject, if given, must be the authenticated patient.
+func recordRequestFromObservation(userID string, obs *fhirObservation) (HealthRecordRequest, error) {
+	var req HealthRecordRequest
+	if obs.ResourceType != "Observation" {
+		return req, fmt.Errorf("resource must be an Observation, not %q", obs.ResourceType)
+	}
+	switch obs.Status {
+	case "final", "amended", "corrected", "preliminary":
+	default:
+		return req, fmt.Errorf("status %q cannot be imported (final, amended, corrected or preliminary)", obs.Status)
+	}
+	if obs.Subject != nil && obs.Subject.Reference != "Patient/"+userID {
+		return req, errors.New("subject must be the authenticated patient (Patient/" + userID + ")")
+	}
+
+	quantity := obs.ValueQuantity
+	if fhirHasCode(obs.Code, fhirBloodPressurePanel) {
+		// Only the systolic value can be kept; refuse rather than drop the diastolic
+		req.Type, quantity = "blood_pressure", nil
+		for _, component := range obs.Component {
+			switch {
+			case fhirHasCode(component.Code, fhirDiastolic):
+				return req, errors.New("diastolic blood pressure (8462-4) cannot be stored; send the systolic value as an 8480-6 Observation")
+			case fhirHasCode(component.Code, fhirSystolic):
+				quantity = component.ValueQuantity
+			}
+		}
+		if quantity == nil {
+			return req, errors.New("blood pressure panel must have a systolic component (8480-6)")
+		}
+	} else {
+		recordType, ok := fhirRecordType(obs.Code)
+		if !ok {
+			return req, errors.New("code must include a supported LOINC code (8867-4, 29463-7, 8480-6, 8310-5, 2339-0)")
+		}
+		req.Type = recordType
+	}
+	if quantity == nil || quantity.Value == nil {
+		return req, errors.New("valueQuantity.value is required")
+	}
+	req.Value = *quantity.Value
+	req.Unit = quantity.Unit
+	if unit, ok := fhirRecordUnits[quantity.Code]; ok && quantity.System == fhirUCUM {
+		req.Unit = unit
+	} else if req.Unit == "" {
+		req.Unit = quantity.Code
+	}
+
+	if obs.EffectiveDateTime != "" {
+		if _, err := time.Parse(time.RFC3339, obs.EffectiveDateTime); err != nil {
+			return req, errors.New("effectiveDateTime must be a full date and time with zone")
+		}
+		req.RecordedAt = obs.EffectiveDateTime
+	}
+	var notes []string
+	for _, note := range obs.Note {
+		notes = append(notes, note.Text)
+	}
+	req.Notes = strings.Join(notes, "\n")
+	return req, nil
+}
+
+// ----------------------------------------------------------------------------
+// Search and read
+// ----------------------------------------------------------------------------
+
+// fhirDateFilter is one date= parameter: a prefix and the range [start, end)
+// of the value's precision (a day, or an instant)
+type fhirDateFilter struct {
+	prefix     string
+	start, end time.Time
+}
+
+func parseFHIRDate(param string) (fhirDateFilter, error) {
+	filter := fhirDateFilter{prefix: "eq"}
+	for _, prefix := range []string{"eq", "ge", "gt", "le", "lt"} {
+		if strings.HasPrefix(param, prefix) {
+			filter.prefix, param = prefix, param[2:]
+			break
+		}
+	}
+	if t, err := time.Parse(time.RFC3339, param); err == nil {
+		filter.start, filter.end = t, t.Add(time.Nanosecond)
+		return filter, nil
+	}
+	if t, err := time.Parse("2006-01-02", param); err == nil {
+		filter.start, filter.end = t, t.AddDate(0, 0, 1)
+		return filter, nil
+	}
+	return filter, fmt.Errorf("invalid date %q (YYYY-MM-DD or date-time with zone, optional prefix eq, ge, gt, le, lt)", param)
+}
+
+// narrow restricts q's recorded_at range [Since, Until) to the filter
+func (f fhirDateFilter) narrow(q *HealthRecordQuery) {
+	since, until := time.Time{}, time.Time{}
+	switch f.prefix {
+	case "ge":
+		since = f.start
+	case "gt":
+		since = f.end
+	case "le":
+		until = f.end
+	case "lt":
+		until = f.start
+	default:
+		since, until = f.start, f.end
+	}
+	if !since.IsZero() && since.After(q.Since) {
+		q.Since = since
+	}
+	if !until.IsZero() && (q.Until.IsZero() || until.Before(q.Until)) {
+		q.Until = until
+	}
+}
+
+// searchObservationsHandler searches the caller's Observations
+// GET /fhir/Observation?patient=<id>[&code=][&date=][&_count=][&_offset=] (protected)
+// CONFIDENTIALITY: Only the authenticated patient's compartment
+func searchObservationsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("user").(string)
+	if !ok {
+		writeFHIRError(w, http.StatusUnauthorized, "login", "Unauthorized")
+		return
+	}
+	params := r.URL.Query()
+
+	for _, name := range []string{"patient", "subject"} {
+		if patient := params.Get(name); patient != "" && strings.TrimPrefix(patient, "Patient/") != userID {
+			writeFHIRError(w, http.StatusForbidden, "forbidden", "Observations of other patients cannot be searched")
+			return
+		}
+	}
+
+	// code: comma-separated tokens, [system|]code
+	var types []string
+	if raw := params.Get("code"); raw != "" {
+		for _, token := range strings.Split(raw, ",") {
+			system, code, found := strings.Cut(token, "|")
+			if !found {
+				system, code = fhirLOINC, system
+			}
+			if recordType, ok := fhirRecordType(fhirCodeableConcept{Coding: []fhirCoding{{System: system, Code: code}}}); ok {
+				types = append(types, recordType)
+			}
+		}
+		if len(types) == 0 {
+			writeFHIR(w, http.StatusOK, searchBundle(r, nil, 0, 0, 0))
+			return
+		}
+	}
+
+	// Type, date range and page are applied by the store
+	query := HealthRecordQuery{Types: types}
+	for _, raw := range params["date"] {
+		filter, err := parseFHIRDate(raw)
+		if err != nil {
+			writeFHIRError(w, http.StatusBadRequest, "invalid", err.Error())
+			return
+		}
+		filter.narrow(&query)
+	}
+
+	count, offset := fhirDefaultCount, 0
+	if raw := params.Get("_count"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			writeFHIRError(w, http.StatusBadRequest, "invalid", "Invalid '_count' parameter")
+			return
+		}
+		count = min(n, fhirMaxCount)
+	}
+	if raw := params.Get("_offset"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			writeFHIRError(w, http.StatusBadRequest, "invalid", "Invalid '_offset' parameter")
+			return
+		}
+		offset = n
+	}
+
+	if !query.Until.IsZero() && !query.Since.Before(query.Until) {
+		writeFHIR(w, http.StatusOK, searchBundle(r, nil, 0, count, offset)) // disjoint date filters
+		return
+	}
+	total, err := healthStore.Count(r.Context(), userID, query)
+	if err != nil {
+		log.Printf("[HEALTH] Failed to count records: %v", err)
+		writeFHIRError(w, http.StatusInternalServerError, "exception", "Failed to load records")
+		return
+	}
+	var page []*HealthRecord
+	if count > 0 && offset < total {
+		query.Offset, query.Limit = offset, count
+		if page, err = healthStore.List(r.Context(), userID, query); err != nil {
+			log.Printf("[HEALTH] Failed to list records: %v", err)
+			writeFHIRError(w, http.StatusInternalServerError, "exception", "Failed to load records")
+			return
+		}
+	}
+	writeFHIR(w, http.StatusOK, searchBundle(r, page, total, count, offset))
+}
+
+// searchBundle builds the searchset for one page of matches (records holds
+// the page's records)
+func searchBundle(r *http.Request, records []*HealthRecord, total, count, offset int) *fhirBundle {
+	bundle := &fhirBundle{ResourceType: "Bundle", Type: "searchset", Timestamp: fhirInstant(time.Now()), Total: &total}
+	base := fhirBase(r)
+	page := func(offset int) string {
+		params := r.URL.Query()
+		params.Set("_count", strconv.Itoa(count))
+		params.Set("_offset", strconv.Itoa(offset))
+		return base + "Observation?" + params.Encode()
+	}
+	bundle.Link = []fhirBundleLink{{Relation: "self", URL: base + "Observation?" + r.URL.RawQuery}}
+	if offset < total && count > 0 {
+		end := min(offset+count, total)
+		for _, record := range records {
+			raw, _ := json.Marshal(observationFromRecord(record))
+			bundle.Entry = append(bundle.Entry, fhirBundleEntry{
+				FullURL:  base + "Observation/" + record.ID,
+				Resource: raw,
+				Search:   &fhirBundleSearch{Mode: "match"},
+			})
+		}
+		if end < total {
+			bundle.Link = append(bundle.Link, fhirBundleLink{Relation: "next", URL: page(end)})
+		}
+	}
+	if offset > 0 && count > 0 {
+		bundle.Link = append(bundle.Link, fhirBundleLink{Relation: "previous", URL: page(max(offset-count, 0))})
+	}
+	return bundle
+}
+
+// readObservationHandler returns one Observation
+// GET /fhir/Observation/{id} (protected)
+func readObservationHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("user").(string)
+	if !ok {
+		writeFHIRError(w, http.StatusUnauthorized, "login", "Unauthorized")
+		return
+	}
+	record, err := healthStore.Get(r.Context(), userID, chi.URLParam(r, "id"))
+	if err == errRecordNotFound {
+		writeFHIRError(w, http.StatusNotFound, "not-found", "Observation not found")
+		return
+	}
+	if err != nil {
+		log.Printf("[HEALTH] Failed to load record: %v", err)
+		writeFHIRError(w, http.StatusInternalServerError, "exception", "Failed to load record")
+		return
+	}
+	// FHIR version tags are weak (W/"3"); If-None-Match compares weakly
+	w.Header().Set("ETag", "W/"+recordETag(record.Version))
+	if notModified(r, recordETag(record.Version)) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	writeFHIR(w, http.StatusOK, observationFromRecord(record))
+}
+
+// ----------------------------------------------------------------------------
+// Bundle import
+// ----------------------------------------------------------------------------
+
+// importFHIRBundleHandler creates the Observations of a transaction or batch Bundle
+// POST /fhir/Bundle (protected)
+// INTEGRITY: Same validation as create; a transaction is all or nothing
+// AVAILABILITY: Body size and entry count limited by the import config
+func importFHIRBundleHandler(cfg ImportConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("user").(string)
+		if !ok {
+			writeFHIRError(w, http.StatusUnauthorized, "login", "Unauthorized")
+			return
+		}
+
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if mediaType != "application/fhir+json" && mediaType != "application/json" {
+			writeFHIRError(w, http.StatusUnsupportedMediaType, "not-supported", "Content-Type must be application/fhir+json")
+			return
+		}
+		if r.ContentLength > cfg.MaxBytes {
+			writeFHIRError(w, http.StatusRequestEntityTooLarge, "too-costly", fmt.Sprintf("Bundle larger than %d bytes", cfg.MaxBytes))
+			return
+		}
+		body := http.MaxBytesReader(w, r.Body, cfg.MaxBytes)
+		defer body.Close()
+
+		var bundle fhirBundle
+		if err := json.NewDecoder(body).Decode(&bundle); err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				writeFHIRError(w, http.StatusRequestEntityTooLarge, "too-costly", fmt.Sprintf("Bundle larger than %d bytes", cfg.MaxBytes))
+				return
+			}
+			writeFHIRError(w, http.StatusBadRequest, "structure", "Request body must be a JSON Bundle")
+			return
+		}
+		if bundle.ResourceType != "Bundle" || (bundle.Type != "transaction" && bundle.Type != "batch") {
+			writeFHIRError(w, http.StatusBadRequest, "invalid", "Resource must be a Bundle of type transaction or batch")
+			return
+		}
+		if len(bundle.Entry) == 0 {
+			writeFHIRError(w, http.StatusBadRequest, "invalid", "Bundle has no entries")
+			return
+		}
+		if len(bundle.Entry) > cfg.MaxRows {
+			writeFHIRError(w, http.StatusRequestEntityTooLarge, "too-costly", fmt.Sprintf("Bundle has more than %d entries", cfg.MaxRows))
+			return
+		}
+		transaction := bundle.Type == "transaction"
+
+		// Map and validate every entry like a single create (INTEGRITY)
+		records := make([]*HealthRecord, len(bundle.Entry))
+		entryIssues := make([][]fhirIssue, len(bundle.Entry))
+		var invalid []fhirIssue
+		for i, entry := range bundle.Entry {
+			expression := []string{fmt.Sprintf("Bundle.entry[%d]", i)}
+			record, err := fhirEntryRecord(userID, entry)
+			if err != nil {
+				issue := fhirIssue{Severity: "error", Code: "invalid", Diagnostics: err.Error(), Expression: expression}
+				entryIssues[i] = append(entryIssues[i], issue)
+				invalid = append(invalid, issue)
+				continue
+			}
+			records[i] = record
+		}
+
+		// Store (CONFIDENTIALITY: sensitive fields encrypted at rest by the store)
+		statuses := make([]string, len(records))
+		for i, record := range records {
+			if record == nil {
+				statuses[i] = "400 Bad Request"
+			}
+		}
+		if transaction {
+			if len(invalid) > 0 {
+				writeFHIR(w, http.StatusBadRequest, newOperationOutcome(invalid...))
+				return
+			}
+			if err := healthStore.CreateMany(r.Context(), records); err != nil {
+				log.Printf("[HEALTH] Failed to import FHIR transaction: %v", err)
+				writeFHIRError(w, http.StatusInternalServerError, "exception", "Failed to import records")
+				return
+			}
+			for i := range statuses {
+				statuses[i] = "201 Created"
+			}
+		} else {
+			for i, record := range records {
+				if record == nil {
+					continue
+				}
+				if err := healthStore.Create(r.Context(), record); err != nil {
+					log.Printf("[HEALTH] Failed to store imported record: %v", err)
+					statuses[i] = "500 Internal Server Error"
+					entryIssues[i] = append(entryIssues[i], fhirIssue{Severity: "error", Code: "exception", Diagnostics: "Failed to store record",
+						Expression: []string{fmt.Sprintf("Bundle.entry[%d]", i)}})
+					continue
+				}
+				statuses[i] = "201 Created"
+			}
+		}
+
+		response := &fhirBundle{ResourceType: "Bundle", Type: bundle.Type + "-response", Timestamp: fhirInstant(time.Now())}
+		var types []string
+		imported := 0
+		for i, record := range records {
+			entry := fhirBundleEntry{Response: &fhirBundleResponse{Status: statuses[i]}}
+			if statuses[i] == "201 Created" {
+				imported++
+				if !slices.Contains(types, record.Type) {
+					types = append(types, record.Type)
+				}
+				entry.FullURL = fhirBase(r) + "Observation/" + record.ID
+				entry.Response.Location = "Observation/" + record.ID + "/_history/1"
+				entry.Response.Etag = "W/" + recordETag(record.Version)
+				entry.Response.LastModified = fhirInstant(record.CreatedAt)
+			}
+			if len(entryIssues[i]) > 0 {
+				entry.Response.Outcome = newOperationOutcome(entryIssues[i]...)
+			}
+			response.Entry = append(response.Entry, entry)
+		}
+
+		if imported > 0 {
+			// Invalidate stats cache (AVAILABILITY: invalidate on write)
+			healthRecordsChanged(r.Context(), userID, types...)
+		}
+		auditEvent(r.Context(), userID, auditRecordsImported, "FHIR %s imported: %d of %d Observation(s) for user %s",
+			bundle.Type, imported, len(records), userID)
+
+		writeFHIR(w, http.StatusOK, response)
+	}
+}
+
+// fhirEntryRecord validates one Bundle entry (POST of an Observation) and
+// builds the record to store
+func fhirEntryRecord(userID string, entry fhirBundleEntry) (*HealthRecord, error) {
+	if entry.Request == nil || entry.Request.Method != http.MethodPost || strings.TrimPrefix(entry.Request.URL, "/") != "Observation" {
+		return nil, errors.New("only POST Observation entries are supported")
+	}
+	var obs fhirObservation
+	if err := json.Unmarshal(entry.Resource, &obs); err != nil {
+		return nil, errors.New("resource is not a valid Observation")
+	}
+	req, err := recordRequestFromObservation(userID, &obs)
+	if err != nil {
+		return nil, err
+	}
+	return newHealthRecord(userID, req)
+}
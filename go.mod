@@ -11,6 +11,7 @@ require (
 	github.com/google/uuid v1.6.0
 	github.com/lib/pq v1.10.9
 	golang.org/x/crypto v0.43.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+)
+
+// ============================================================================
+// Health Record At-Rest Encoding (CONFIDENTIALITY)
+// ============================================================================
+
+// storedHealthRecord is the form written to storage: Notes (and optionally
+// Value) are encrypted with the owner's data key (see envelope.go).
+// Legacy plaintext records decode into it unchanged.
+type storedHealthRecord struct {
+	ID         string     `json:"id"`
+	UserID     string     `json:"user_id"`
+	Type       string     `json:"type"`
+	Value      float64    `json:"value,omitempty"`
+	ValueEnc   string     `json:"value_enc,omitempty"`
+	Unit       string     `json:"unit"`
+	Notes      string     `json:"notes"`
+	RecordedAt time.Time  `json:"recorded_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+	Version    int        `json:"version,omitempty"` // 0 in records written before updates existed
+	UpdatedAt  *time.Time `json:"updated_at,omitempty"`
+	UpdatedBy  string     `json:"updated_by,omitempty"`
+	DeletedAt  *time.Time `json:"deleted_at,omitempty"`
+}
+
+// healthRecordField names an encrypted field of a record for userAAD.
+// Revisions keep the record's ID, so they open with the same name.
+func healthRecordField(recordID, name string) string {
+	return "health:" + recordID + ":" + name
+}
+
+// sealHealthRecord encrypts sensitive fields and returns the at-rest form
+func sealHealthRecord(ctx context.Context, rec *HealthRecord) (*storedHealthRecord, error) {
+	notes, err := encryptForUser(ctx, rec.UserID, healthRecordField(rec.ID, "notes"), rec.Notes)
+	if err != nil {
+		return nil, err
+	}
+	stored := storedHealthRecord{
+		ID:         rec.ID,
+		UserID:     rec.UserID,
+		Type:       rec.Type,
+		Value:      rec.Value,
+		Unit:       rec.Unit,
+		Notes:      notes,
+		RecordedAt: rec.RecordedAt,
+		CreatedAt:  rec.CreatedAt,
+		Version:    rec.Version,
+		UpdatedAt:  rec.UpdatedAt,
+		UpdatedBy:  rec.UpdatedBy,
+		DeletedAt:  rec.DeletedAt,
+	}
+	if securityConfig.EncryptHealthValues && keyProvider != nil {
+		valueEnc, err := encryptForUser(ctx, rec.UserID, healthRecordField(rec.ID, "value"), strconv.FormatFloat(rec.Value, 'g', -1, 64))
+		if err != nil {
+			return nil, err
+		}
+		stored.Value, stored.ValueEnc = 0, valueEnc
+	}
+	return &stored, nil
+}
+
+// openHealthRecord decrypts the sensitive fields of an at-rest record
+func openHealthRecord(ctx context.Context, stored *storedHealthRecord) (*HealthRecord, error) {
+	notes, err := decryptForUser(ctx, stored.UserID, healthRecordField(stored.ID, "notes"), stored.Notes)
+	if err != nil {
+		return nil, err
+	}
+	value, err := openHealthValue(ctx, stored)
+	if err != nil {
+		return nil, err
+	}
+	version := stored.Version
+	if version == 0 {
+		version = 1
+	}
+	return &HealthRecord{
+		ID:         stored.ID,
+		UserID:     stored.UserID,
+		Type:       stored.Type,
+		Value:      value,
+		Unit:       stored.Unit,
+		Notes:      notes,
+		RecordedAt: stored.RecordedAt,
+		CreatedAt:  stored.CreatedAt,
+		Version:    version,
+		UpdatedAt:  stored.UpdatedAt,
+		UpdatedBy:  stored.UpdatedBy,
+		DeletedAt:  stored.DeletedAt,
+	}, nil
+}
+
+// openHealthValue returns the plaintext value (decrypting ValueEnc if set)
+func openHealthValue(ctx context.Context, stored *storedHealthRecord) (float64, error) {
+	if stored.ValueEnc == "" {
+		return stored.Value, nil
+	}
+	plain, err := decryptForUser(ctx, stored.UserID, healthRecordField(stored.ID, "value"), stored.ValueEnc)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(plain, 64)
+}
+
+// marshalHealthRecord encrypts sensitive fields and returns the stored JSON (Redis form)
+func marshalHealthRecord(ctx context.Context, rec *HealthRecord) ([]byte, error) {
+	stored, err := sealHealthRecord(ctx, rec)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(stored)
+}
+
+// unmarshalHealthRecord decodes stored JSON and decrypts sensitive fields
+func unmarshalHealthRecord(ctx context.Context, data []byte) (*HealthRecord, error) {
+	var stored storedHealthRecord
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, err
+	}
+	return openHealthRecord(ctx, &stored)
+}
@@ -1,12 +1,17 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"slices"
+	"strings"
 	"time"
 
+	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
 )
 
@@ -47,15 +52,47 @@ func createHealthRecordHandler(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 
 	// Validate input (INTEGRITY)
+	record, err := newHealthRecord(userID, req)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	// Store (CONFIDENTIALITY: sensitive fields encrypted at rest by the store)
+	if err := healthStore.Create(r.Context(), record); err != nil {
+		log.Printf("[HEALTH] Failed to store record: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to create record"})
+		return
+	}
+
+	// Invalidate stats cache (AVAILABILITY: invalidate on write)
+	healthRecordsChanged(r.Context(), userID, req.Type)
+
+	auditEvent(r.Context(), userID, auditRecordCreated, "Health record created: %s for user: %s", record.ID, userID)
+
+	// Return created record
+	w.Header().Set("Content-Type", "application/json")
+	setRecordETag(w, record)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(record)
+}
+
+// newHealthRecord validates req and builds the record to store (shared by
+// create and import)
+func newHealthRecord(userID string, req HealthRecordRequest) (*HealthRecord, error) {
+	// The request's own rules (e.g. notes length), then the value range
+	if err := validate.Struct(req); err != nil {
+		return nil, err
+	}
 	input := HealthRecordInput{
 		Type:  req.Type,
 		Value: req.Value,
 		Unit:  req.Unit,
 	}
 	if err := validate.Struct(input); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
-		return
+		return nil, err
 	}
 
 	// Parse recorded time
@@ -66,8 +103,7 @@ func createHealthRecordHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	// Create health record
-	record := &HealthRecord{
+	return &HealthRecord{
 		ID:         uuid.New().String(),
 		UserID:     userID,
 		Type:       req.Type,
@@ -76,34 +112,8 @@ func createHealthRecordHandler(w http.ResponseWriter, r *http.Request) {
 		Notes:      req.Notes,
 		RecordedAt: recordedAt,
 		CreatedAt:  time.Now(),
-	}
-
-	// Store in cache (AVAILABILITY: fast retrieval)
-	recordKey := fmt.Sprintf("health:%s:%s", userID, record.ID)
-	recordJSON, _ := json.Marshal(record)
-	ttl := 30 * 24 * time.Hour // 30 days
-	if err := rdb.Set(r.Context(), recordKey, recordJSON, ttl).Err(); err != nil {
-		log.Printf("[HEALTH] Failed to store record: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to create record"})
-		return
-	}
-
-	// Add to user's health record list (for indexing)
-	listKey := fmt.Sprintf("health:%s:list", userID)
-	rdb.LPush(r.Context(), listKey, record.ID)
-	rdb.Expire(r.Context(), listKey, ttl)
-
-	// Invalidate stats cache (AVAILABILITY: invalidate on write)
-	statsKey := fmt.Sprintf("health:%s:stats:%s", userID, req.Type)
-	rdb.Del(r.Context(), statsKey)
-
-	log.Printf("[AUDIT] Health record created: %s for user: %s", record.ID, userID)
-
-	// Return created record
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(record)
+		Version:    1,
+	}, nil
 }
 
 // getHealthRecordsHandler retrieves health records for the authenticated user
@@ -132,27 +142,20 @@ func getHealthRecordsHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	// Retrieve from cache (AVAILABILITY: fast reads with caching)
-	listKey := fmt.Sprintf("health:%s:list", userID)
-	recordIDs, err := rdb.LRange(r.Context(), listKey, 0, int64(limit-1)).Result()
-	if err != nil {
-		// If list not found, return empty
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode([]HealthRecord{})
+	// Optional metric filter (served by the (user_id, type, recorded_at) index)
+	query := HealthRecordQuery{Type: r.URL.Query().Get("type"), Limit: limit}
+	if query.Type != "" && !slices.Contains(healthRecordTypes, query.Type) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid 'type' parameter"})
 		return
 	}
 
-	var records []*HealthRecord
-	for _, id := range recordIDs {
-		recordKey := fmt.Sprintf("health:%s:%s", userID, id)
-		recordJSON, err := rdb.Get(r.Context(), recordKey).Result()
-		if err == nil {
-			var record HealthRecord
-			if err := json.Unmarshal([]byte(recordJSON), &record); err == nil {
-				records = append(records, &record)
-			}
-		}
+	records, err := healthStore.List(r.Context(), userID, query)
+	if err != nil {
+		log.Printf("[HEALTH] Failed to list records: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to load records"})
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -164,6 +167,41 @@ func getHealthRecordsHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(records)
 }
 
+// getHealthRecordHandler returns one record with its version as ETag
+// GET /api/v1/health/{id} (protected)
+// AVAILABILITY: If-None-Match answers 304 without a body
+func getHealthRecordHandler(w http.ResponseWriter, r *http.Request) {
+	// Get user ID from context
+	userID, ok := r.Context().Value("user").(string)
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Unauthorized"})
+		return
+	}
+
+	record, err := healthStore.Get(r.Context(), userID, chi.URLParam(r, "id"))
+	if err == errRecordNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Record not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("[HEALTH] Failed to load record: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to load record"})
+		return
+	}
+
+	setRecordETag(w, record)
+	if notModified(r, recordETag(record.Version)) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(record)
+}
+
 // getHealthStatsHandler returns aggregated stats for a specific health metric type
 // GET /api/v1/health/stats?type=heart_rate (protected)
 // AVAILABILITY: Cached aggregation results
@@ -189,70 +227,196 @@ func getHealthStatsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check cache first (AVAILABILITY: fast aggregation)
-	statsKey := fmt.Sprintf("health:%s:stats:%s", userID, recordType)
-	statsJSON, err := rdb.Get(r.Context(), statsKey).Result()
-	if err == nil {
-		// Cache hit
-		var stats HealthStats
-		if err := json.Unmarshal([]byte(statsJSON), &stats); err == nil {
-			w.Header().Set("Content-Type", "application/json")
-			w.Header().Set("X-Cache", "HIT")
-			w.WriteHeader(http.StatusOK)
-			json.NewEncoder(w).Encode(stats)
-			return
-		}
+	// Cache-aside (AVAILABILITY): concurrent misses share one aggregation
+	stats, status, err := healthStatsCache.Get(r.Context(), healthStatsKey(userID, recordType),
+		func(ctx context.Context) (*HealthStats, error) {
+			stats, err := healthStore.Stats(ctx, userID, recordType)
+			if err == nil && stats.Count == 0 {
+				return nil, errNoHealthStats
+			}
+			return stats, err
+		})
+	if errors.Is(err, errNoHealthStats) {
+		stats, err = &HealthStats{}, nil
+	}
+	if err != nil {
+		log.Printf("[HEALTH] Failed to compute stats: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to compute statistics"})
+		return
 	}
 
-	// Cache miss: compute stats from all records
-	listKey := fmt.Sprintf("health:%s:list", userID)
-	recordIDs, _ := rdb.LRange(r.Context(), listKey, 0, -1).Result()
-
-	var values []float64
-	var lastRecord time.Time
-
-	for _, id := range recordIDs {
-		recordKey := fmt.Sprintf("health:%s:%s", userID, id)
-		recordJSON, err := rdb.Get(r.Context(), recordKey).Result()
-		if err == nil {
-			var record HealthRecord
-			if err := json.Unmarshal([]byte(recordJSON), &record); err == nil {
-				if record.Type == recordType {
-					values = append(values, record.Value)
-					if record.RecordedAt.After(lastRecord) {
-						lastRecord = record.RecordedAt
-					}
-				}
-			}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Cache", string(status))
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(stats)
+}
+
+// replaceHealthRecordHandler replaces a record's content (full update)
+// PUT /api/v1/health/{id} (protected)
+// INTEGRITY: Same validation as create; the previous version is kept
+func replaceHealthRecordHandler(w http.ResponseWriter, r *http.Request) {
+	var req HealthRecordRequest
+	updateHealthRecord(w, r, &req, func(rec *HealthRecord) error {
+		rec.Type, rec.Value, rec.Unit, rec.Notes = req.Type, req.Value, req.Unit, req.Notes
+		if req.RecordedAt != "" {
+			return setRecordedAt(rec, req.RecordedAt)
+		}
+		return nil
+	})
+}
+
+// patchHealthRecordHandler changes the fields present in the body
+// PATCH /api/v1/health/{id} (protected)
+func patchHealthRecordHandler(w http.ResponseWriter, r *http.Request) {
+	var patch HealthRecordPatch
+	updateHealthRecord(w, r, &patch, func(rec *HealthRecord) error {
+		if patch.Type != nil {
+			rec.Type = *patch.Type
+		}
+		if patch.Value != nil {
+			rec.Value = *patch.Value
 		}
+		if patch.Unit != nil {
+			rec.Unit = *patch.Unit
+		}
+		if patch.Notes != nil {
+			rec.Notes = *patch.Notes
+		}
+		if patch.RecordedAt != nil {
+			return setRecordedAt(rec, *patch.RecordedAt)
+		}
+		return nil
+	})
+}
+
+// invalidUpdateError is a validation failure of an updated record (400)
+type invalidUpdateError struct{ msg string }
+
+func (e invalidUpdateError) Error() string { return e.msg }
+
+// setRecordedAt parses an RFC 3339 timestamp into rec
+func setRecordedAt(rec *HealthRecord, raw string) error {
+	t, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		return invalidUpdateError{"recorded_at must be RFC 3339"}
 	}
+	rec.RecordedAt = t
+	return nil
+}
 
-	// Calculate aggregates
-	var stats HealthStats
-	if len(values) > 0 {
-		stats = HealthStats{
-			UserID:     userID,
-			Type:       recordType,
-			Count:      len(values),
-			Average:    calculateAverage(values),
-			Min:        calculateMin(values),
-			Max:        calculateMax(values),
-			LastRecord: lastRecord,
+// updateHealthRecord decodes body, applies apply to the stored record and
+// validates the result like a new record before it is written. If-Match is
+// checked against the version read inside the store's update (412).
+func updateHealthRecord(w http.ResponseWriter, r *http.Request, body interface{}, apply func(*HealthRecord) error) {
+	// Validate request size (INTEGRITY)
+	if !ValidateRequestSize(w, r) {
+		return
+	}
+
+	// Get user ID from context (set by jwtMiddleware)
+	userID, ok := r.Context().Value("user").(string)
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Unauthorized"})
+		return
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(body); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid request body"})
+		return
+	}
+	defer r.Body.Close()
+
+	recordID := chi.URLParam(r, "id")
+	ifMatch := versionPrecondition(r)
+	var previousType string
+	record, err := healthStore.Update(r.Context(), userID, recordID, requestPrincipal(r), func(rec *HealthRecord) error {
+		if ifMatch != nil && !ifMatch(rec.Version) {
+			return errPreconditionFailed
+		}
+		previousType = rec.Type
+		if err := apply(rec); err != nil {
+			return err
+		}
+		// Validate input (INTEGRITY)
+		input := HealthRecordInput{Type: rec.Type, Value: rec.Value, Unit: rec.Unit}
+		if err := validate.Struct(input); err != nil {
+			return invalidUpdateError{err.Error()}
+		}
+		if len(rec.Notes) > 500 {
+			return invalidUpdateError{"notes must be at most 500 characters"}
 		}
+		if looksEncrypted(rec.Notes) {
+			return invalidUpdateError{`notes must not start with "enc:"`}
+		}
+		return nil
+	})
+	var invalid invalidUpdateError
+	switch {
+	case err == errRecordNotFound:
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Record not found"})
+		return
+	case err == errPreconditionFailed:
+		w.WriteHeader(http.StatusPreconditionFailed)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Record has been modified"})
+		return
+	case errors.As(err, &invalid):
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": invalid.msg})
+		return
+	case err != nil:
+		log.Printf("[HEALTH] Failed to update record: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to update record"})
+		return
+	}
+
+	// Both the old and the new type's aggregates may have changed
+	healthRecordsChanged(r.Context(), userID, previousType, record.Type)
+
+	auditEvent(r.Context(), userID, auditRecordUpdated, "Health record updated: %s to version %d by %s", recordID, record.Version, userID)
+
+	w.Header().Set("Content-Type", "application/json")
+	setRecordETag(w, record)
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(record)
+}
+
+// getHealthRecordHistoryHandler lists every version of a record, oldest first
+// GET /api/v1/health/{id}/history (protected)
+// INTEGRITY: Who changed a reading and when (clinical audit)
+func getHealthRecordHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	// Get user ID from context
+	userID, ok := r.Context().Value("user").(string)
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Unauthorized"})
+		return
+	}
 
-		// Cache stats for 1 hour (AVAILABILITY)
-		statsJSON, _ := json.Marshal(stats)
-		rdb.Set(r.Context(), statsKey, statsJSON, 1*time.Hour)
+	revisions, err := healthStore.History(r.Context(), userID, chi.URLParam(r, "id"))
+	if err == errRecordNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Record not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("[HEALTH] Failed to load record history: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to load history"})
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("X-Cache", "MISS")
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(stats)
+	json.NewEncoder(w).Encode(revisions)
 }
 
 // deleteHealthRecordHandler deletes a specific health record
-// DELETE /api/v1/health/:id (protected)
+// DELETE /api/v1/health/{id} or /api/v1/health?id= (protected)
 func deleteHealthRecordHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodDelete {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -267,37 +431,157 @@ func deleteHealthRecordHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Get record ID from URL
-	recordID := r.URL.Query().Get("id")
+	// Get record ID from the path (or ?id=, kept for existing clients)
+	recordID := chi.URLParam(r, "id")
+	if recordID == "" {
+		recordID = r.URL.Query().Get("id")
+	}
 	if recordID == "" {
 		w.WriteHeader(http.StatusBadRequest)
 		json.NewEncoder(w).Encode(map[string]string{"error": "Missing 'id' parameter"})
 		return
 	}
 
-	// Delete from cache
-	recordKey := fmt.Sprintf("health:%s:%s", userID, recordID)
-	if err := rdb.Del(r.Context(), recordKey).Err(); err != nil {
+	err := healthStore.Delete(r.Context(), userID, recordID, versionPrecondition(r))
+	if err == errRecordNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Record not found"})
+		return
+	}
+	if err == errPreconditionFailed {
+		w.WriteHeader(http.StatusPreconditionFailed)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Record has been modified"})
+		return
+	}
+	if err != nil {
+		log.Printf("[HEALTH] Failed to delete record: %v", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to delete record"})
 		return
 	}
 
-	// Remove from list index
-	listKey := fmt.Sprintf("health:%s:list", userID)
-	rdb.LRem(r.Context(), listKey, 1, recordID)
+	// The record's type is unknown here, so drop every cached aggregate
+	healthRecordsChanged(r.Context(), userID)
 
-	log.Printf("[AUDIT] Health record deleted: %s for user %s", recordID, userID)
+	auditEvent(r.Context(), userID, auditRecordDeleted, "Health record deleted: %s for user %s", recordID, userID)
 
+	// Soft delete: restorable until the sweeper removes it
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{"message": "Record deleted successfully"})
+	json.NewEncoder(w).Encode(map[string]string{
+		"message":       "Record deleted successfully",
+		"restore_until": time.Now().Add(retentionSettings.RestoreWindow.D()).UTC().Format(time.RFC3339),
+	})
+}
+
+// restoreHealthRecordHandler undoes a delete within retention.restore_window
+// POST /api/v1/health/{id}/restore (protected)
+// INTEGRITY: A misclicked delete does not lose clinical data
+func restoreHealthRecordHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Get user ID from context
+	userID, ok := r.Context().Value("user").(string)
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Unauthorized"})
+		return
+	}
+
+	recordID := chi.URLParam(r, "id")
+	since := time.Now().Add(-retentionSettings.RestoreWindow.D())
+	record, err := healthStore.Restore(r.Context(), userID, recordID, since)
+	switch {
+	case err == errRecordNotFound:
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Record not found"})
+		return
+	case err == errRecordNotDeleted:
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Record is not deleted"})
+		return
+	case err == errRestoreWindowPassed:
+		w.WriteHeader(http.StatusGone)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Restore window has passed"})
+		return
+	case err != nil:
+		log.Printf("[HEALTH] Failed to restore record: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to restore record"})
+		return
+	}
+
+	healthRecordsChanged(r.Context(), userID, record.Type)
+
+	auditEvent(r.Context(), userID, auditRecordRestored, "Health record restored: %s for user %s", recordID, userID)
+
+	w.Header().Set("Content-Type", "application/json")
+	setRecordETag(w, record)
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(record)
 }
 
 // ============================================================================
 // Helper functions for statistics
 // ============================================================================
 
+// errNoHealthStats marks "no records of this type" (cached negatively)
+var errNoHealthStats = errors.New("no records for stats")
+
+// healthStatsCache holds aggregates per user and type (see initCaches)
+var healthStatsCache = newCache[HealthStats]("stats", 0, errNoHealthStats, healthStatsCodec())
+
+func init() {
+	onHealthRecordsChanged(invalidateHealthStats)
+}
+
+// healthStatsKey is the cache key for a user's aggregate of one type
+func healthStatsKey(userID, recordType string) string {
+	return userID + ":" + recordType
+}
+
+// healthStatsCodec encrypts cached aggregates with the owner's data key
+// when health values are encrypted
+func healthStatsCodec() cacheCodec[HealthStats] {
+	plain := jsonCacheCodec[HealthStats]()
+	owner := func(key string) string {
+		userID, _, _ := strings.Cut(key, ":")
+		return userID
+	}
+	return cacheCodec[HealthStats]{
+		encode: func(ctx context.Context, key string, v *HealthStats) ([]byte, error) {
+			data, err := plain.encode(ctx, key, v)
+			if err != nil || !securityConfig.EncryptHealthValues {
+				return data, err
+			}
+			sealed, err := encryptForUser(ctx, owner(key), "cache:stats:"+key, string(data))
+			return []byte(sealed), err
+		},
+		decode: func(ctx context.Context, key string, data []byte) (*HealthStats, error) {
+			opened, err := decryptForUser(ctx, owner(key), "cache:stats:"+key, string(data))
+			if err != nil {
+				return nil, err
+			}
+			return plain.decode(ctx, key, []byte(opened))
+		},
+	}
+}
+
+// invalidateHealthStats drops cached aggregates of the given types (all if none)
+func invalidateHealthStats(ctx context.Context, userID string, recordTypes []string) {
+	if len(recordTypes) == 0 {
+		recordTypes = healthRecordTypes
+	}
+	keys := make([]string, 0, len(recordTypes))
+	for _, t := range recordTypes {
+		keys = append(keys, healthStatsKey(userID, t))
+	}
+	healthStatsCache.Invalidate(ctx, keys...)
+}
+
 func calculateAverage(values []float64) float64 {
 	if len(values) == 0 {
 		return 0
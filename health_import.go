@@ -0,0 +1,307 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ============================================================================
+// Bulk import of health records (CSV or JSON array)
+// ============================================================================
+//
+// POST /api/v1/health/import takes many readings at once (paper logs, other
+// apps). Every row goes through the same validation as a single create; the
+// response reports each row. By default valid rows are stored and invalid
+// ones skipped; with ?atomic=true one invalid row rejects the whole import.
+// Body size and row count are capped by the import config section.
+
+// Row statuses in the import report
+const (
+	importRowCreated = "created" // stored
+	importRowInvalid = "invalid" // failed validation
+	importRowFailed  = "failed"  // valid, but could not be stored
+	importRowSkipped = "skipped" // valid, not stored because the atomic import was rejected
+)
+
+// healthImportReport is the response of an import
+type healthImportReport struct {
+	Atomic   bool              `json:"atomic"`
+	Total    int               `json:"total"`
+	Imported int               `json:"imported"`
+	Failed   int               `json:"failed"`
+	Rows     []healthImportRow `json:"rows"`
+}
+
+// healthImportRow is the outcome of one row (1-based, header not counted)
+type healthImportRow struct {
+	Row    int    `json:"row"`
+	Status string `json:"status"`
+	ID     string `json:"id,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// importRequest is a parsed row; err is set when the row could not be read
+type importRequest struct {
+	req HealthRecordRequest
+	err error
+}
+
+// errTooManyRows rejects an import over import.max_rows
+var errTooManyRows = errors.New("too many rows")
+
+// importHealthRecordsHandler imports records for the authenticated user
+// POST /api/v1/health/import[?atomic=true] (protected)
+// Content-Type: text/csv (header: type,value,unit[,notes][,recorded_at]) or application/json (array)
+// INTEGRITY: Same validation as create, optional all-or-nothing
+// AVAILABILITY: Body size and row count limited
+func importHealthRecordsHandler(cfg ImportConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		// Get user ID from context (set by jwtMiddleware)
+		userID, ok := r.Context().Value("user").(string)
+		if !ok {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Unauthorized"})
+			return
+		}
+
+		atomic := false
+		if raw := r.URL.Query().Get("atomic"); raw != "" {
+			var err error
+			if atomic, err = strconv.ParseBool(raw); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]string{"error": "Invalid 'atomic' parameter"})
+				return
+			}
+		}
+
+		// Validate request size (AVAILABILITY: import has its own limit)
+		if r.ContentLength > cfg.MaxBytes {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("Import larger than %d bytes", cfg.MaxBytes)})
+			return
+		}
+		body := http.MaxBytesReader(w, r.Body, cfg.MaxBytes)
+		defer body.Close()
+
+		var rows []importRequest
+		var err error
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		switch mediaType {
+		case "text/csv", "application/csv":
+			rows, err = parseImportCSV(body, cfg.MaxRows)
+		case "application/json":
+			rows, err = parseImportJSON(body, cfg.MaxRows)
+		default:
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Content-Type must be text/csv or application/json"})
+			return
+		}
+		var tooLarge *http.MaxBytesError
+		switch {
+		case errors.As(err, &tooLarge):
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("Import larger than %d bytes", cfg.MaxBytes)})
+			return
+		case errors.Is(err, errTooManyRows):
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("Import has more than %d rows", cfg.MaxRows)})
+			return
+		case err != nil:
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		case len(rows) == 0:
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "No rows to import"})
+			return
+		}
+
+		// Validate every row like a single create (INTEGRITY)
+		report := &healthImportReport{Atomic: atomic, Total: len(rows), Rows: make([]healthImportRow, len(rows))}
+		records := make([]*HealthRecord, len(rows))
+		for i, row := range rows {
+			report.Rows[i].Row = i + 1
+			err := row.err
+			if err == nil && row.req.RecordedAt != "" {
+				if _, perr := time.Parse(time.RFC3339, row.req.RecordedAt); perr != nil {
+					err = errors.New("recorded_at must be RFC 3339")
+				}
+			}
+			if err == nil {
+				records[i], err = newHealthRecord(userID, row.req)
+			}
+			if err != nil {
+				report.Rows[i].Status, report.Rows[i].Error = importRowInvalid, err.Error()
+				report.Failed++
+			}
+		}
+
+		// Store (CONFIDENTIALITY: sensitive fields encrypted at rest by the store)
+		if atomic {
+			if report.Failed > 0 {
+				for i := range report.Rows {
+					if records[i] != nil {
+						report.Rows[i].Status = importRowSkipped
+					}
+				}
+			} else if err := healthStore.CreateMany(r.Context(), records); err != nil {
+				log.Printf("[HEALTH] Failed to import records: %v", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				json.NewEncoder(w).Encode(map[string]string{"error": "Failed to import records"})
+				return
+			} else {
+				for i, record := range records {
+					report.Rows[i].Status, report.Rows[i].ID = importRowCreated, record.ID
+				}
+				report.Imported = len(records)
+			}
+		} else {
+			for i, record := range records {
+				if record == nil {
+					continue
+				}
+				if err := healthStore.Create(r.Context(), record); err != nil {
+					log.Printf("[HEALTH] Failed to store imported record: %v", err)
+					report.Rows[i].Status, report.Rows[i].Error = importRowFailed, "Failed to store record"
+					report.Failed++
+					continue
+				}
+				report.Rows[i].Status, report.Rows[i].ID = importRowCreated, record.ID
+				report.Imported++
+			}
+		}
+
+		if report.Imported > 0 {
+			// Invalidate stats cache (AVAILABILITY: invalidate on write)
+			var types []string
+			for i, record := range records {
+				if report.Rows[i].Status == importRowCreated && !slices.Contains(types, record.Type) {
+					types = append(types, record.Type)
+				}
+			}
+			healthRecordsChanged(r.Context(), userID, types...)
+		}
+
+		auditEvent(r.Context(), userID, auditRecordsImported, "Health records imported: %d of %d row(s) for user %s (atomic %v)",
+			report.Imported, report.Total, userID, atomic)
+
+		status := http.StatusOK // partial import: see the per-row report
+		switch {
+		case report.Failed == 0:
+			status = http.StatusCreated
+		case report.Imported == 0:
+			status = http.StatusUnprocessableEntity
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(report)
+	}
+}
+
+// parseImportCSV reads rows under a header naming the columns (any order,
+// case-insensitive; unknown columns are ignored)
+func parseImportCSV(body io.Reader, maxRows int) ([]importRequest, error) {
+	reader := csv.NewReader(body)
+	reader.FieldsPerRecord = -1 // short rows are reported per row
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, csvImportError(err)
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		if i == 0 {
+			name = strings.TrimPrefix(name, "\ufeff") // spreadsheet byte order mark
+		}
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, required := range []string{"type", "value", "unit"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("CSV header must include %q (columns: type,value,unit,notes,recorded_at)", required)
+		}
+	}
+
+	var rows []importRequest
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, csvImportError(err)
+		}
+		if len(rows) == maxRows {
+			return nil, errTooManyRows
+		}
+		field := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+
+		row := importRequest{req: HealthRecordRequest{
+			Type:       field("type"),
+			Unit:       field("unit"),
+			Notes:      field("notes"),
+			RecordedAt: field("recorded_at"),
+		}}
+		if raw := field("value"); raw != "" {
+			if row.req.Value, err = strconv.ParseFloat(raw, 64); err != nil {
+				row.err = errors.New("value must be a number")
+			}
+		}
+		rows = append(rows, row)
+	}
+}
+
+// csvImportError keeps a read error from the body as is (size limit)
+func csvImportError(err error) error {
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return fmt.Errorf("invalid CSV: %v", parseErr)
+	}
+	return err
+}
+
+// parseImportJSON reads an array of create request bodies; an element that
+// does not decode is reported for its row only
+func parseImportJSON(body io.Reader, maxRows int) ([]importRequest, error) {
+	var elements []json.RawMessage
+	if err := json.NewDecoder(body).Decode(&elements); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return nil, err
+		}
+		return nil, errors.New("request body must be a JSON array of records")
+	}
+	if len(elements) > maxRows {
+		return nil, errTooManyRows
+	}
+	rows := make([]importRequest, len(elements))
+	for i, element := range elements {
+		if err := json.Unmarshal(element, &rows[i].req); err != nil {
+			rows[i].err = errors.New("invalid record")
+		}
+	}
+	return rows, nil
+}
@@ -0,0 +1,519 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ============================================================================
+// HealthRecordStore: durable health records (PostgreSQL when configured, else the KV store)
+// ============================================================================
+
+var (
+	errRecordNotFound      = errors.New("health record not found")
+	errRecordNotDeleted    = errors.New("health record is not deleted")
+	errRestoreWindowPassed = errors.New("restore window has passed")
+)
+
+// HealthRecordQuery filters List and Count. Zero values mean "no filter";
+// Limit 0 means all.
+type HealthRecordQuery struct {
+	Type   string
+	Types  []string  // any of these (FHIR code=a,b)
+	Since  time.Time // recorded at or after
+	Until  time.Time // recorded before
+	Offset int       // matches to skip (List only)
+	Limit  int
+}
+
+// matches reports whether a live record passes the filters (not Offset/Limit)
+func (q HealthRecordQuery) matches(stored *storedHealthRecord) bool {
+	switch {
+	case stored.DeletedAt != nil:
+		return false
+	case q.Type != "" && stored.Type != q.Type:
+		return false
+	case len(q.Types) > 0 && !slices.Contains(q.Types, stored.Type):
+		return false
+	case !q.Since.IsZero() && stored.RecordedAt.Before(q.Since):
+		return false
+	case !q.Until.IsZero() && !stored.RecordedAt.Before(q.Until):
+		return false
+	}
+	return true
+}
+
+// HealthRecordStore persists health records. Records are always scoped by
+// owner: a record ID alone never reads or deletes another user's data.
+type HealthRecordStore interface {
+	Create(ctx context.Context, rec *HealthRecord) error
+	// CreateMany stores all records or none of them (bulk import). The KV
+	// store gets there by undoing a partial write, see kvHealthStore.CreateMany.
+	CreateMany(ctx context.Context, recs []*HealthRecord) error
+	// Get returns a live (not deleted) record
+	Get(ctx context.Context, userID, id string) (*HealthRecord, error)
+	List(ctx context.Context, userID string, q HealthRecordQuery) ([]*HealthRecord, error) // newest first
+	// Count returns the number of live records matching q (for paging totals)
+	Count(ctx context.Context, userID string, q HealthRecordQuery) (int, error)
+	Stats(ctx context.Context, userID, recordType string) (*HealthStats, error)
+	// Update applies fn to a live record, bumps its version and keeps the
+	// previous version in the record's history. fn may reject the change by
+	// returning an error, which Update returns as is.
+	Update(ctx context.Context, userID, id, changedBy string, fn func(*HealthRecord) error) (*HealthRecord, error)
+	// History returns every version of a live record, oldest first
+	History(ctx context.Context, userID, id string) ([]*HealthRecordRevision, error)
+	// Delete marks a record deleted: List and Stats skip it until it is
+	// restored or PurgeDeleted removes it. A non-nil ifMatch must accept the
+	// current version (else errPreconditionFailed).
+	Delete(ctx context.Context, userID, id string, ifMatch func(version int) bool) error
+	// Restore clears the mark of a record deleted at or after since
+	// (errRecordNotDeleted, errRestoreWindowPassed)
+	Restore(ctx context.Context, userID, id string, since time.Time) (*HealthRecord, error)
+	// PurgeDeleted removes records marked deleted before cutoff, except those
+	// of exempt users; returns the number of records per user
+	PurgeDeleted(ctx context.Context, cutoff time.Time, exempt []string) (map[string]int, error)
+	DeleteAllForUser(ctx context.Context, userID string) (int, error) // right to erasure
+	// Purge deletes records of recordType recorded before cutoff, except those
+	// of exempt users (legal hold); with dryRun it only counts. Returns the
+	// number of records per user.
+	Purge(ctx context.Context, recordType string, cutoff time.Time, exempt []string, dryRun bool) (map[string]int, error)
+}
+
+var healthStore HealthRecordStore
+
+// healthChangeHooks run after a user's records change (e.g. stats cache
+// invalidation); writers call healthRecordsChanged
+var healthChangeHooks []func(ctx context.Context, userID string, recordTypes []string)
+
+// onHealthRecordsChanged registers an invalidation hook
+func onHealthRecordsChanged(hook func(ctx context.Context, userID string, recordTypes []string)) {
+	healthChangeHooks = append(healthChangeHooks, hook)
+}
+
+// healthRecordsChanged notifies the hooks; no recordTypes means any type
+func healthRecordsChanged(ctx context.Context, userID string, recordTypes ...string) {
+	for _, hook := range healthChangeHooks {
+		hook(ctx, userID, recordTypes)
+	}
+}
+
+// initHealthStore selects PostgreSQL when a database is configured, otherwise the KV store
+func initHealthStore(db *sql.DB) {
+	if db == nil {
+		healthStore = kvHealthStore{}
+		infof("[STORE] Health records: key-value store (no database configured)")
+		return
+	}
+	healthStore = newPostgresHealthStore(db)
+	infof("[STORE] Health records: PostgreSQL")
+}
+
+// aggregateHealthStats computes stats in Go (used where the store cannot
+// aggregate, e.g. encrypted values). Returns zero stats for no values.
+func aggregateHealthStats(userID, recordType string, values []float64, lastRecord time.Time) *HealthStats {
+	if len(values) == 0 {
+		return &HealthStats{}
+	}
+	return &HealthStats{
+		UserID:     userID,
+		Type:       recordType,
+		Count:      len(values),
+		Average:    calculateAverage(values),
+		Min:        calculateMin(values),
+		Max:        calculateMax(values),
+		LastRecord: lastRecord,
+	}
+}
+
+// ----------------------------------------------------------------------------
+// Key-value store: health:<uid>:<id> strings + health:<uid>:list, and
+// health:<uid>:<id>:history lists of previous versions (newest first)
+// ----------------------------------------------------------------------------
+//
+// Records do not expire; retention.go purges them by type and age. Delete
+// sets deleted_at in the record and leaves it in the list until swept.
+
+type kvHealthStore struct{}
+
+func healthRecordKey(userID, id string) string {
+	return fmt.Sprintf("health:%s:%s", userID, id)
+}
+
+func healthListKey(userID string) string {
+	return fmt.Sprintf("health:%s:list", userID)
+}
+
+func healthHistoryKey(userID, id string) string {
+	return fmt.Sprintf("health:%s:%s:history", userID, id)
+}
+
+func (kvHealthStore) Create(ctx context.Context, rec *HealthRecord) error {
+	recordJSON, err := marshalHealthRecord(ctx, rec)
+	if err != nil {
+		return err
+	}
+	if err := kv.Set(ctx, healthRecordKey(rec.UserID, rec.ID), recordJSON, 0); err != nil {
+		return err
+	}
+	// Add to user's health record list (for indexing)
+	kv.LPush(ctx, healthListKey(rec.UserID), rec.ID)
+	return nil
+}
+
+// CreateMany is write-then-undo, not a transaction: a MULTI cannot span the
+// records' keys in Cluster mode. Every record is encrypted before anything
+// is written, then records are stored one by one and added to the list
+// index in one LPUSH at the end, so List shows none of them until all are
+// stored. If a write fails, the records already written are deleted again.
+// Until then Get can find them by ID, and a crash in between leaves them
+// stored but unlisted.
+func (kvHealthStore) CreateMany(ctx context.Context, recs []*HealthRecord) error {
+	sealed := make([][]byte, len(recs))
+	for i, rec := range recs {
+		recordJSON, err := marshalHealthRecord(ctx, rec)
+		if err != nil {
+			return err
+		}
+		sealed[i] = recordJSON
+	}
+
+	written := 0
+	undo := func() {
+		for _, rec := range recs[:written] {
+			if err := deleteKVHealthRecord(ctx, rec.UserID, rec.ID); err != nil {
+				warnf("[HEALTH] Cannot undo partial import of %s: %v", rec.ID, err)
+			}
+		}
+	}
+	ids := make(map[string][]string) // per user, in order
+	for i, rec := range recs {
+		if err := kv.Set(ctx, healthRecordKey(rec.UserID, rec.ID), sealed[i], 0); err != nil {
+			undo()
+			return err
+		}
+		written++
+		ids[rec.UserID] = append(ids[rec.UserID], rec.ID)
+	}
+	for userID, userIDs := range ids {
+		if err := kv.LPush(ctx, healthListKey(userID), userIDs...); err != nil {
+			undo()
+			return err
+		}
+	}
+	return nil
+}
+
+// Get only accepts UUIDs, as the database does: other IDs ("list",
+// "<id>:history") would name the index or history lists
+func (kvHealthStore) Get(ctx context.Context, userID, id string) (*HealthRecord, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, errRecordNotFound
+	}
+	raw, err := kv.Get(ctx, healthRecordKey(userID, id))
+	if err == errKeyNotFound {
+		return nil, errRecordNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	record, err := unmarshalHealthRecord(ctx, raw)
+	if err != nil {
+		return nil, err
+	}
+	if record.DeletedAt != nil {
+		return nil, errRecordNotFound
+	}
+	return record, nil
+}
+
+// List filters on the at-rest form and decrypts only the records it returns
+func (kvHealthStore) List(ctx context.Context, userID string, q HealthRecordQuery) ([]*HealthRecord, error) {
+	var records []*HealthRecord
+	skipped := 0
+	err := eachKVHealthRecord(ctx, userID, q, func(stored *storedHealthRecord) bool {
+		if skipped < q.Offset {
+			skipped++
+			return true
+		}
+		record, err := openHealthRecord(ctx, stored)
+		if err != nil {
+			log.Printf("[HEALTH] Failed to decode record %s: %v", stored.ID, err)
+			return true
+		}
+		records = append(records, record)
+		return q.Limit <= 0 || len(records) < q.Limit
+	})
+	return records, err
+}
+
+func (kvHealthStore) Count(ctx context.Context, userID string, q HealthRecordQuery) (int, error) {
+	count := 0
+	err := eachKVHealthRecord(ctx, userID, q, func(*storedHealthRecord) bool {
+		count++
+		return true
+	})
+	return count, err
+}
+
+// eachKVHealthRecord calls fn with the user's records matching q, newest
+// first, until fn returns false
+func eachKVHealthRecord(ctx context.Context, userID string, q HealthRecordQuery, fn func(*storedHealthRecord) bool) error {
+	recordIDs, err := kv.LRange(ctx, healthListKey(userID))
+	if err != nil {
+		return err
+	}
+	for _, id := range recordIDs {
+		recordJSON, err := kv.Get(ctx, healthRecordKey(userID, id))
+		if err == errKeyNotFound {
+			continue // expired
+		}
+		if err != nil {
+			return err
+		}
+		var stored storedHealthRecord
+		if err := json.Unmarshal(recordJSON, &stored); err != nil {
+			log.Printf("[HEALTH] Failed to decode record %s: %v", id, err)
+			continue
+		}
+		if q.matches(&stored) && !fn(&stored) {
+			break
+		}
+	}
+	return nil
+}
+
+func (s kvHealthStore) Stats(ctx context.Context, userID, recordType string) (*HealthStats, error) {
+	records, err := s.List(ctx, userID, HealthRecordQuery{Type: recordType})
+	if err != nil {
+		return nil, err
+	}
+	var values []float64
+	var lastRecord time.Time
+	for _, record := range records {
+		values = append(values, record.Value)
+		if record.RecordedAt.After(lastRecord) {
+			lastRecord = record.RecordedAt
+		}
+	}
+	return aggregateHealthStats(userID, recordType, values, lastRecord), nil
+}
+
+// Update rewrites the record optimistically, then pushes the replaced
+// version (as stored, still encrypted) onto its history list
+func (kvHealthStore) Update(ctx context.Context, userID, id, changedBy string, fn func(*HealthRecord) error) (*HealthRecord, error) {
+	var previous []byte
+	var updated *HealthRecord
+	err := updateKVHealthRecord(ctx, userID, id, func(stored *storedHealthRecord) error {
+		if stored.DeletedAt != nil {
+			return errRecordNotFound
+		}
+		var err error
+		previous, err = json.Marshal(stored)
+		if err != nil {
+			return err
+		}
+		updated, err = openHealthRecord(ctx, stored)
+		if err != nil {
+			return err
+		}
+		if err := fn(updated); err != nil {
+			return err
+		}
+		now := time.Now().UTC()
+		updated.Version++
+		updated.UpdatedAt, updated.UpdatedBy = &now, changedBy
+		sealed, err := sealHealthRecord(ctx, updated)
+		if err != nil {
+			return err
+		}
+		*stored = *sealed
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := kv.LPush(ctx, healthHistoryKey(userID, id), string(previous)); err != nil {
+		warnf("[HEALTH] Record %s updated but its previous version was not kept: %v", id, err)
+	}
+	return updated, nil
+}
+
+func (s kvHealthStore) History(ctx context.Context, userID, id string) ([]*HealthRecordRevision, error) {
+	current, err := s.Get(ctx, userID, id)
+	if err != nil {
+		return nil, err
+	}
+	previous, err := kv.LRange(ctx, healthHistoryKey(userID, id))
+	if err != nil {
+		return nil, err
+	}
+	revisions := make([]*HealthRecordRevision, 0, len(previous)+1)
+	for i := len(previous) - 1; i >= 0; i-- {
+		rec, err := unmarshalHealthRecord(ctx, []byte(previous[i]))
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, revisionOf(rec))
+	}
+	return append(revisions, revisionOf(current)), nil
+}
+
+func (kvHealthStore) Delete(ctx context.Context, userID, id string, ifMatch func(version int) bool) error {
+	return updateKVHealthRecord(ctx, userID, id, func(stored *storedHealthRecord) error {
+		if stored.DeletedAt != nil {
+			return errRecordNotFound
+		}
+		if ifMatch != nil && !ifMatch(max(stored.Version, 1)) {
+			return errPreconditionFailed
+		}
+		now := time.Now().UTC()
+		stored.DeletedAt = &now
+		return nil
+	})
+}
+
+func (kvHealthStore) Restore(ctx context.Context, userID, id string, since time.Time) (*HealthRecord, error) {
+	var restored *storedHealthRecord
+	err := updateKVHealthRecord(ctx, userID, id, func(stored *storedHealthRecord) error {
+		switch {
+		case stored.DeletedAt == nil:
+			return errRecordNotDeleted
+		case stored.DeletedAt.Before(since):
+			return errRestoreWindowPassed
+		}
+		stored.DeletedAt = nil
+		restored = stored
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return openHealthRecord(ctx, restored)
+}
+
+func (kvHealthStore) PurgeDeleted(ctx context.Context, cutoff time.Time, exempt []string) (map[string]int, error) {
+	purged := make(map[string]int)
+	err := scanKVHealthRecords(ctx, exempt, func(stored *storedHealthRecord) error {
+		if stored.DeletedAt == nil || !stored.DeletedAt.Before(cutoff) {
+			return nil
+		}
+		if err := deleteKVHealthRecord(ctx, stored.UserID, stored.ID); err != nil {
+			return err
+		}
+		purged[stored.UserID]++
+		return nil
+	})
+	return purged, err
+}
+
+func (kvHealthStore) DeleteAllForUser(ctx context.Context, userID string) (int, error) {
+	listKey := healthListKey(userID)
+	recordIDs, err := kv.LRange(ctx, listKey)
+	if err != nil {
+		return 0, err
+	}
+	keys := []string{listKey}
+	for _, id := range recordIDs {
+		keys = append(keys, healthRecordKey(userID, id), healthHistoryKey(userID, id))
+	}
+	_, err = kv.Del(ctx, keys...)
+	return len(recordIDs), err
+}
+
+// Purge removes records by type and age, deleted or not
+func (kvHealthStore) Purge(ctx context.Context, recordType string, cutoff time.Time, exempt []string, dryRun bool) (map[string]int, error) {
+	purged := make(map[string]int)
+	err := scanKVHealthRecords(ctx, exempt, func(stored *storedHealthRecord) error {
+		if stored.Type != recordType || !stored.RecordedAt.Before(cutoff) {
+			return nil
+		}
+		if !dryRun {
+			if err := deleteKVHealthRecord(ctx, stored.UserID, stored.ID); err != nil {
+				return err
+			}
+		}
+		purged[stored.UserID]++
+		return nil
+	})
+	return purged, err
+}
+
+// updateKVHealthRecord applies fn to the at-rest record (optimistically,
+// retrying when another writer got there first). Errors from fn are returned
+// as is and leave the record unchanged.
+func updateKVHealthRecord(ctx context.Context, userID, id string, fn func(*storedHealthRecord) error) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return errRecordNotFound
+	}
+	for attempt := 0; ; attempt++ {
+		err := kv.Update(ctx, healthRecordKey(userID, id), func(current []byte) ([]byte, error) {
+			var stored storedHealthRecord
+			if err := json.Unmarshal(current, &stored); err != nil {
+				return nil, err
+			}
+			if err := fn(&stored); err != nil {
+				return nil, err
+			}
+			return json.Marshal(&stored)
+		})
+		switch {
+		case err == errKeyNotFound:
+			return errRecordNotFound
+		case err == errKeyChanged && attempt < 3:
+			continue
+		}
+		return err
+	}
+}
+
+// deleteKVHealthRecord removes a record, its history and its list entry for good
+func deleteKVHealthRecord(ctx context.Context, userID, id string) error {
+	if _, err := kv.Del(ctx, healthRecordKey(userID, id), healthHistoryKey(userID, id)); err != nil {
+		return err
+	}
+	return kv.LRem(ctx, healthListKey(userID), id)
+}
+
+// scanKVHealthRecords SCANs health:<uid>:<id> records of users not in
+// exempt. Type, recorded_at and deleted_at are stored in plaintext, so
+// nothing is decrypted.
+func scanKVHealthRecords(ctx context.Context, exempt []string, fn func(*storedHealthRecord) error) error {
+	var cursor uint64
+	for {
+		keys, next, err := kv.Scan(ctx, cursor, "health:*", 500)
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			parts := strings.Split(key, ":")
+			if len(parts) != 3 || parts[2] == "list" || slices.Contains(exempt, parts[1]) {
+				continue
+			}
+			raw, err := kv.Get(ctx, key)
+			if err != nil {
+				continue // deleted meanwhile
+			}
+			var stored storedHealthRecord
+			if err := json.Unmarshal(raw, &stored); err != nil {
+				log.Printf("[HEALTH] Failed to decode record %s: %v", key, err)
+				continue
+			}
+			if err := fn(&stored); err != nil {
+				return err
+			}
+		}
+		if next == 0 {
+			return nil
+		}
+		cursor = next
+	}
+}
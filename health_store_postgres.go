@@ -0,0 +1,548 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// ============================================================================
+// PostgreSQL HealthRecordStore
+// ============================================================================
+//
+// Records are kept until retention.go purges them; Delete only sets
+// deleted_at, and list/stats skip such rows. Queries use the (user_id, type,
+// recorded_at) index, so list and stats no longer walk every record.
+// Notes (and optionally values) stay encrypted with the owner's data key.
+
+const healthRecordColumns = `id, user_id, type, value, value_enc, unit, notes, recorded_at, created_at,
+	version, updated_at, updated_by, deleted_at`
+
+type postgresHealthStore struct {
+	db *sql.DB
+}
+
+// newPostgresHealthStore uses the health_records table (migrations/0002_create_health_records.up.sql)
+func newPostgresHealthStore(db *sql.DB) *postgresHealthStore {
+	return &postgresHealthStore{db: db}
+}
+
+func (s *postgresHealthStore) Create(ctx context.Context, rec *HealthRecord) error {
+	stored, err := sealHealthRecord(ctx, rec)
+	if err != nil {
+		return err
+	}
+	return insertHealthRecord(ctx, s.db, stored, false)
+}
+
+// CreateMany inserts all records in one transaction
+func (s *postgresHealthStore) CreateMany(ctx context.Context, recs []*HealthRecord) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, rec := range recs {
+		stored, err := sealHealthRecord(ctx, rec)
+		if err != nil {
+			return err
+		}
+		if err := insertHealthRecord(ctx, tx, stored, false); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// sqlExecer is a *sql.DB or *sql.Tx
+type sqlExecer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// insertStored inserts an already-encrypted record for migrate up; an
+// existing ID is left untouched, so the migration can be re-run
+func (s *postgresHealthStore) insertStored(ctx context.Context, stored *storedHealthRecord) error {
+	return insertHealthRecord(ctx, s.db, stored, true)
+}
+
+// insertHealthRecord inserts a sealed record. An existing ID is an error
+// unless keepExisting (migrations only), which skips the row instead.
+func insertHealthRecord(ctx context.Context, db sqlExecer, stored *storedHealthRecord, keepExisting bool) error {
+	value, valueEnc := storedHealthValue(stored)
+	query := `INSERT INTO health_records (` + healthRecordColumns + `) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)`
+	if keepExisting {
+		query += ` ON CONFLICT (id) DO NOTHING`
+	}
+	_, err := db.ExecContext(ctx, query,
+		stored.ID, stored.UserID, stored.Type, value, valueEnc, stored.Unit, stored.Notes,
+		stored.RecordedAt, stored.CreatedAt, max(stored.Version, 1), stored.UpdatedAt,
+		sql.NullString{String: stored.UpdatedBy, Valid: stored.UpdatedBy != ""}, stored.DeletedAt)
+	return err
+}
+
+// insertRevision keeps a replaced version (as stored, still encrypted). An
+// existing version is an error unless keepExisting (migrate up).
+func insertRevision(ctx context.Context, db sqlExecer, stored *storedHealthRecord, keepExisting bool) error {
+	value, valueEnc := storedHealthValue(stored)
+	changedBy, changedAt := stored.UserID, stored.CreatedAt
+	if stored.UpdatedAt != nil {
+		changedBy, changedAt = stored.UpdatedBy, *stored.UpdatedAt
+	}
+	query := `INSERT INTO health_record_revisions
+	                 (record_id, version, user_id, type, value, value_enc, unit, notes, recorded_at, changed_by, changed_at)
+	          VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`
+	if keepExisting {
+		query += ` ON CONFLICT (record_id, version) DO NOTHING`
+	}
+	_, err := db.ExecContext(ctx, query,
+		stored.ID, max(stored.Version, 1), stored.UserID, stored.Type, value, valueEnc, stored.Unit, stored.Notes,
+		stored.RecordedAt, changedBy, changedAt)
+	return err
+}
+
+// storedHealthValue returns the value columns: plaintext or encrypted, the other NULL
+func storedHealthValue(stored *storedHealthRecord) (sql.NullFloat64, sql.NullString) {
+	if stored.ValueEnc != "" {
+		return sql.NullFloat64{}, sql.NullString{String: stored.ValueEnc, Valid: true}
+	}
+	return sql.NullFloat64{Float64: stored.Value, Valid: true}, sql.NullString{}
+}
+
+// healthQueryWhere returns the WHERE clause selecting userID's live records
+// that match q, and its arguments
+func healthQueryWhere(userID string, q HealthRecordQuery) (string, []interface{}) {
+	where := ` WHERE user_id = $1 AND deleted_at IS NULL`
+	args := []interface{}{userID}
+	param := func(v interface{}) string {
+		args = append(args, v)
+		return `$` + strconv.Itoa(len(args))
+	}
+	if q.Type != "" {
+		where += ` AND type = ` + param(q.Type)
+	}
+	if len(q.Types) > 0 {
+		where += ` AND type = ANY(` + param(pq.Array(q.Types)) + `)`
+	}
+	if !q.Since.IsZero() {
+		where += ` AND recorded_at >= ` + param(q.Since)
+	}
+	if !q.Until.IsZero() {
+		where += ` AND recorded_at < ` + param(q.Until)
+	}
+	return where, args
+}
+
+func (s *postgresHealthStore) List(ctx context.Context, userID string, q HealthRecordQuery) ([]*HealthRecord, error) {
+	where, args := healthQueryWhere(userID, q)
+	query := `SELECT ` + healthRecordColumns + ` FROM health_records` + where + ` ORDER BY recorded_at DESC, id`
+	if q.Limit > 0 {
+		args = append(args, q.Limit)
+		query += ` LIMIT $` + strconv.Itoa(len(args))
+	}
+	if q.Offset > 0 {
+		args = append(args, q.Offset)
+		query += ` OFFSET $` + strconv.Itoa(len(args))
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []*HealthRecord
+	for rows.Next() {
+		stored, err := scanHealthRecord(rows)
+		if err != nil {
+			return nil, err
+		}
+		record, err := openHealthRecord(ctx, stored)
+		if err != nil {
+			log.Printf("[HEALTH] Failed to decode record %s: %v", stored.ID, err)
+			continue
+		}
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
+
+func (s *postgresHealthStore) Count(ctx context.Context, userID string, q HealthRecordQuery) (int, error) {
+	where, args := healthQueryWhere(userID, q)
+	var count int
+	err := s.db.QueryRowContext(ctx, `SELECT count(*) FROM health_records`+where, args...).Scan(&count)
+	return count, err
+}
+
+// Stats aggregates plaintext values in SQL and decrypts encrypted ones
+// (ENCRYPT_HEALTH_VALUES) in Go; both read only the (user_id, type) index range.
+func (s *postgresHealthStore) Stats(ctx context.Context, userID, recordType string) (*HealthStats, error) {
+	var count int
+	var sum, min, max sql.NullFloat64
+	var last sql.NullTime
+	err := s.db.QueryRowContext(ctx,
+		`SELECT count(*), sum(value), min(value), max(value), max(recorded_at)
+		   FROM health_records
+		  WHERE user_id = $1 AND type = $2 AND value_enc IS NULL AND deleted_at IS NULL`,
+		userID, recordType).Scan(&count, &sum, &min, &max, &last)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT value_enc, recorded_at FROM health_records
+		  WHERE user_id = $1 AND type = $2 AND value_enc IS NOT NULL AND deleted_at IS NULL`,
+		userID, recordType)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	total, sumAll := count, sum.Float64
+	minAll, maxAll, lastRecord := min.Float64, max.Float64, last.Time
+	for rows.Next() {
+		stored := storedHealthRecord{UserID: userID}
+		if err := rows.Scan(&stored.ValueEnc, &stored.RecordedAt); err != nil {
+			return nil, err
+		}
+		value, err := openHealthValue(ctx, &stored)
+		if err != nil {
+			return nil, err
+		}
+		if total == 0 || value < minAll {
+			minAll = value
+		}
+		if total == 0 || value > maxAll {
+			maxAll = value
+		}
+		if stored.RecordedAt.After(lastRecord) {
+			lastRecord = stored.RecordedAt
+		}
+		total++
+		sumAll += value
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if total == 0 {
+		return &HealthStats{}, nil
+	}
+	return &HealthStats{
+		UserID:     userID,
+		Type:       recordType,
+		Average:    sumAll / float64(total),
+		Min:        minAll,
+		Max:        maxAll,
+		Count:      total,
+		LastRecord: lastRecord,
+	}, nil
+}
+
+// Update locks the row, keeps the current version in
+// health_record_revisions and rewrites the row, all in one transaction
+func (s *postgresHealthStore) Update(ctx context.Context, userID, id, changedBy string, fn func(*HealthRecord) error) (*HealthRecord, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, errRecordNotFound
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx,
+		`SELECT `+healthRecordColumns+` FROM health_records
+		  WHERE id = $1 AND user_id = $2 AND deleted_at IS NULL
+		    FOR UPDATE`, id, userID)
+	if err != nil {
+		return nil, err
+	}
+	var previous *storedHealthRecord
+	if rows.Next() {
+		previous, err = scanHealthRecord(rows)
+	}
+	rows.Close()
+	if err == nil {
+		err = rows.Err()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if previous == nil {
+		return nil, errRecordNotFound
+	}
+
+	updated, err := openHealthRecord(ctx, previous)
+	if err != nil {
+		return nil, err
+	}
+	if err := fn(updated); err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	updated.Version++
+	updated.UpdatedAt, updated.UpdatedBy = &now, changedBy
+	stored, err := sealHealthRecord(ctx, updated)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := insertRevision(ctx, tx, previous, false); err != nil {
+		return nil, err
+	}
+	value, valueEnc := storedHealthValue(stored)
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE health_records
+		    SET type = $3, value = $4, value_enc = $5, unit = $6, notes = $7, recorded_at = $8,
+		        version = $9, updated_at = $10, updated_by = $11
+		  WHERE id = $1 AND user_id = $2`,
+		id, userID, stored.Type, value, valueEnc, stored.Unit, stored.Notes, stored.RecordedAt,
+		stored.Version, stored.UpdatedAt, stored.UpdatedBy); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+// Get returns a live record; IDs that are not UUIDs are not found
+func (s *postgresHealthStore) Get(ctx context.Context, userID, id string) (*HealthRecord, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, errRecordNotFound
+	}
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT `+healthRecordColumns+` FROM health_records
+		  WHERE id = $1 AND user_id = $2 AND deleted_at IS NULL`, id, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		return nil, errRecordNotFound
+	}
+	stored, err := scanHealthRecord(rows)
+	if err != nil {
+		return nil, err
+	}
+	return openHealthRecord(ctx, stored)
+}
+
+// History reads the replaced versions and appends the current one
+func (s *postgresHealthStore) History(ctx context.Context, userID, id string) ([]*HealthRecordRevision, error) {
+	current, err := s.Get(ctx, userID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT version, type, value, value_enc, unit, notes, recorded_at, changed_by, changed_at
+		   FROM health_record_revisions
+		  WHERE record_id = $1
+		  ORDER BY version`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var revisions []*HealthRecordRevision
+	for rows.Next() {
+		stored := storedHealthRecord{ID: id, UserID: userID}
+		var value sql.NullFloat64
+		var valueEnc sql.NullString
+		var changedAt time.Time
+		if err := rows.Scan(&stored.Version, &stored.Type, &value, &valueEnc, &stored.Unit, &stored.Notes,
+			&stored.RecordedAt, &stored.UpdatedBy, &changedAt); err != nil {
+			return nil, err
+		}
+		stored.Value, stored.ValueEnc, stored.UpdatedAt = value.Float64, valueEnc.String, &changedAt
+		rec, err := openHealthRecord(ctx, &stored)
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, revisionOf(rec))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return append(revisions, revisionOf(current)), nil
+}
+
+// Delete with an If-Match condition locks the row to compare its version;
+// without one a single UPDATE suffices
+func (s *postgresHealthStore) Delete(ctx context.Context, userID, id string, ifMatch func(version int) bool) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return errRecordNotFound
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if ifMatch != nil {
+		var version int
+		err := tx.QueryRowContext(ctx,
+			`SELECT version FROM health_records
+			  WHERE id = $1 AND user_id = $2 AND deleted_at IS NULL
+			    FOR UPDATE`, id, userID).Scan(&version)
+		if err == sql.ErrNoRows {
+			return errRecordNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if !ifMatch(version) {
+			return errPreconditionFailed
+		}
+	}
+	res, err := tx.ExecContext(ctx,
+		`UPDATE health_records SET deleted_at = now()
+		  WHERE id = $1 AND user_id = $2 AND deleted_at IS NULL`, id, userID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return errRecordNotFound
+	}
+	return tx.Commit()
+}
+
+// Restore clears deleted_at in one statement; when nothing matched, a second
+// read tells the caller why
+func (s *postgresHealthStore) Restore(ctx context.Context, userID, id string, since time.Time) (*HealthRecord, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, errRecordNotFound
+	}
+	rows, err := s.db.QueryContext(ctx,
+		`UPDATE health_records SET deleted_at = NULL
+		  WHERE id = $1 AND user_id = $2 AND deleted_at >= $3
+		 RETURNING `+healthRecordColumns, id, userID, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	if rows.Next() {
+		stored, err := scanHealthRecord(rows)
+		if err != nil {
+			return nil, err
+		}
+		return openHealthRecord(ctx, stored)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var deletedAt sql.NullTime
+	err = s.db.QueryRowContext(ctx,
+		`SELECT deleted_at FROM health_records WHERE id = $1 AND user_id = $2`, id, userID).Scan(&deletedAt)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return nil, errRecordNotFound
+	case err != nil:
+		return nil, err
+	case !deletedAt.Valid:
+		return nil, errRecordNotDeleted
+	}
+	return nil, errRestoreWindowPassed
+}
+
+// PurgeDeleted uses the partial index on deleted_at (migrations/0004_soft_delete_health_records.up.sql)
+func (s *postgresHealthStore) PurgeDeleted(ctx context.Context, cutoff time.Time, exempt []string) (map[string]int, error) {
+	if exempt == nil {
+		exempt = []string{}
+	}
+	rows, err := s.db.QueryContext(ctx,
+		`DELETE FROM health_records
+		  WHERE deleted_at < $1 AND NOT (user_id = ANY($2))
+		RETURNING user_id`, cutoff, pq.Array(exempt))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	purged := make(map[string]int)
+	for rows.Next() {
+		var userID string
+		if err := rows.Scan(&userID); err != nil {
+			return nil, err
+		}
+		purged[userID]++
+	}
+	return purged, rows.Err()
+}
+
+func (s *postgresHealthStore) DeleteAllForUser(ctx context.Context, userID string) (int, error) {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM health_records WHERE user_id = $1`, userID)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// Purge deletes (or, dry run, counts) in one statement over the (type,
+// recorded_at) index (migrations/0003_add_retention.up.sql)
+func (s *postgresHealthStore) Purge(ctx context.Context, recordType string, cutoff time.Time, exempt []string, dryRun bool) (map[string]int, error) {
+	query := `DELETE FROM health_records
+	           WHERE type = $1 AND recorded_at < $2 AND NOT (user_id = ANY($3))
+	       RETURNING user_id`
+	if dryRun {
+		query = `SELECT user_id FROM health_records
+		          WHERE type = $1 AND recorded_at < $2 AND NOT (user_id = ANY($3))`
+	}
+	if exempt == nil {
+		exempt = []string{} // a nil array is NULL, and NOT (x = ANY(NULL)) matches nothing
+	}
+	rows, err := s.db.QueryContext(ctx, query, recordType, cutoff, pq.Array(exempt))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	purged := make(map[string]int)
+	for rows.Next() {
+		var userID string
+		if err := rows.Scan(&userID); err != nil {
+			return nil, err
+		}
+		purged[userID]++
+	}
+	return purged, rows.Err()
+}
+
+// scanHealthRecord reads one row in healthRecordColumns order
+func scanHealthRecord(rows *sql.Rows) (*storedHealthRecord, error) {
+	var stored storedHealthRecord
+	var value sql.NullFloat64
+	var valueEnc sql.NullString
+	var recordedAt, createdAt time.Time
+	var updatedAt, deletedAt sql.NullTime
+	var updatedBy sql.NullString
+	if err := rows.Scan(&stored.ID, &stored.UserID, &stored.Type, &value, &valueEnc,
+		&stored.Unit, &stored.Notes, &recordedAt, &createdAt,
+		&stored.Version, &updatedAt, &updatedBy, &deletedAt); err != nil {
+		return nil, err
+	}
+	stored.Value, stored.ValueEnc = value.Float64, valueEnc.String
+	stored.RecordedAt, stored.CreatedAt = recordedAt, createdAt
+	stored.UpdatedBy = updatedBy.String
+	if updatedAt.Valid {
+		stored.UpdatedAt = &updatedAt.Time
+	}
+	if deletedAt.Valid {
+		stored.DeletedAt = &deletedAt.Time
+	}
+	return &stored, nil
+}
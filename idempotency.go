@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+// ============================================================================
+// INTEGRITY: Idempotency-Key support for POST requests
+// ============================================================================
+
+const (
+	idempotencyHeader       = "Idempotency-Key"
+	idempotencyReplayHeader = "Idempotent-Replayed"
+	idempotencyTTL          = 24 * time.Hour
+	idempotencyLockTTL      = time.Minute // max time a first request may stay in flight
+	maxIdempotencyKeyLength = 255
+)
+
+// idempotentResponse is the stored outcome of the first request with a given key
+type idempotentResponse struct {
+	Fingerprint string `json:"fingerprint"` // sha256 of method, path and body
+	Pending     bool   `json:"pending"`
+	Status      int    `json:"status,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	ETag        string `json:"etag,omitempty"`
+	Body        []byte `json:"body,omitempty"`
+}
+
+// IdempotencyMiddleware replays the first response for a repeated
+// (user, Idempotency-Key) pair instead of executing the POST again.
+// Must run after authentication (needs "user" in context).
+func IdempotencyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		idemKey := r.Header.Get(idempotencyHeader)
+		if r.Method != http.MethodPost || idemKey == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(idemKey) > maxIdempotencyKeyLength {
+			writeIdempotencyError(w, http.StatusBadRequest, "Idempotency-Key too long")
+			return
+		}
+
+		userID, ok := r.Context().Value("user").(string)
+		if !ok {
+			writeIdempotencyError(w, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+
+		// Read body for the fingerprint, then restore it for the handler
+		body, err := io.ReadAll(io.LimitReader(r.Body, securityConfig.MaxRequestBodySize+1))
+		if err != nil || int64(len(body)) > securityConfig.MaxRequestBodySize {
+			http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body.Close()
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		fp := sha256.New()
+		fp.Write([]byte(r.Method + "\n" + r.URL.Path + "\n"))
+		fp.Write(body)
+		fingerprint := hex.EncodeToString(fp.Sum(nil))
+
+		keyHash := sha256.Sum256([]byte(idemKey))
+		storeKey := "idempotency:" + userID + ":" + hex.EncodeToString(keyHash[:])
+
+		// Claim the key; only the first request gets to run the handler
+		pending, _ := json.Marshal(idempotentResponse{Fingerprint: fingerprint, Pending: true})
+		claimed, err := kv.SetNX(r.Context(), storeKey, pending, idempotencyLockTTL)
+		if err != nil {
+			log.Printf("[IDEMPOTENCY] Store error: %v", err)
+			writeIdempotencyError(w, http.StatusServiceUnavailable, "Service unavailable")
+			return
+		}
+
+		if !claimed {
+			replayIdempotentResponse(w, r, storeKey, fingerprint)
+			return
+		}
+
+		rec := &recordingResponseWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		// Server errors are not cached so the client can retry with the same key
+		if rec.status >= http.StatusInternalServerError {
+			kv.Del(r.Context(), storeKey)
+			return
+		}
+		stored, _ := json.Marshal(idempotentResponse{
+			Fingerprint: fingerprint,
+			Status:      rec.status,
+			ContentType: rec.Header().Get("Content-Type"),
+			ETag:        rec.Header().Get("ETag"),
+			Body:        rec.body.Bytes(),
+		})
+		if err := kv.Set(r.Context(), storeKey, stored, idempotencyTTL); err != nil {
+			log.Printf("[IDEMPOTENCY] Failed to store response: %v", err)
+		}
+	})
+}
+
+// replayIdempotentResponse answers a retry from the stored first response
+func replayIdempotentResponse(w http.ResponseWriter, r *http.Request, storeKey, fingerprint string) {
+	raw, err := kv.Get(r.Context(), storeKey)
+	if err == errKeyNotFound {
+		// First request failed and released the key between our SETNX and GET
+		writeIdempotencyError(w, http.StatusConflict, "Request with this Idempotency-Key is being retried, try again")
+		return
+	}
+	var prev idempotentResponse
+	if err != nil || json.Unmarshal(raw, &prev) != nil {
+		writeIdempotencyError(w, http.StatusServiceUnavailable, "Service unavailable")
+		return
+	}
+
+	if prev.Fingerprint != fingerprint {
+		writeIdempotencyError(w, http.StatusUnprocessableEntity, "Idempotency-Key reused with a different request payload")
+		return
+	}
+	if prev.Pending {
+		writeIdempotencyError(w, http.StatusConflict, "Request with this Idempotency-Key is still in progress")
+		return
+	}
+
+	if prev.ContentType != "" {
+		w.Header().Set("Content-Type", prev.ContentType)
+	}
+	if prev.ETag != "" {
+		w.Header().Set("ETag", prev.ETag)
+	}
+	w.Header().Set(idempotencyReplayHeader, "true")
+	w.WriteHeader(prev.Status)
+	w.Write(prev.Body)
+}
+
+func writeIdempotencyError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
+
+// recordingResponseWriter passes the response through while keeping a copy
+type recordingResponseWriter struct {
+	http.ResponseWriter
+	status      int
+	body        bytes.Buffer
+	wroteHeader bool
+}
+
+func (rw *recordingResponseWriter) WriteHeader(status int) {
+	if !rw.wroteHeader {
+		rw.status = status
+		rw.wroteHeader = true
+	}
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *recordingResponseWriter) Write(b []byte) (int, error) {
+	rw.wroteHeader = true
+	rw.body.Write(b)
+	return rw.ResponseWriter.Write(b)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+// ============================================================================
+// CONFIDENTIALITY: Master Key (KEK) Providers
+// ============================================================================
+
+// KeyProvider supplies master key-encryption keys (KEKs) by version.
+// KEKs never encrypt data directly; they wrap per-user data keys.
+// Several versions may be active: new writes use the current one, reads
+// accept any registered version (see rotate_keys.go).
+type KeyProvider interface {
+	// CurrentKEK returns the version and key used to wrap new data keys
+	CurrentKEK() (version string, key []byte, err error)
+	// KEK returns a specific version, used to unwrap existing data keys
+	KEK(version string) ([]byte, error)
+}
+
+// reservedKeyVersion marks user-DEK ciphertexts ("enc:dek:...") and
+// cannot be used as a master key version.
+const reservedKeyVersion = "dek"
+
+// keyring is a set of versioned master keys with one current version
+type keyring struct {
+	current string
+	keys    map[string][]byte
+}
+
+func (k *keyring) CurrentKEK() (string, []byte, error) {
+	return k.current, k.keys[k.current], nil
+}
+
+func (k *keyring) KEK(version string) ([]byte, error) {
+	key, ok := k.keys[version]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", errUnknownKeyVersion, version)
+	}
+	return key, nil
+}
+
+// Versions lists registered key versions (sorted)
+func (k *keyring) Versions() []string {
+	versions := make([]string, 0, len(k.keys))
+	for v := range k.keys {
+		versions = append(versions, v)
+	}
+	sort.Strings(versions)
+	return versions
+}
+
+// newKeyring validates and decodes encoded keys into a keyring
+func newKeyring(current string, encoded map[string]string) (*keyring, error) {
+	k := &keyring{current: current, keys: make(map[string][]byte)}
+	for version, enc := range encoded {
+		if version == "" || version == reservedKeyVersion || strings.Contains(version, ":") {
+			return nil, fmt.Errorf("invalid key version %q", version)
+		}
+		key, err := parseEncryptionKey(enc)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", version, err)
+		}
+		k.keys[version] = key
+	}
+	if _, ok := k.keys[k.current]; !ok {
+		return nil, fmt.Errorf("current key version %q not in keyring", k.current)
+	}
+	return k, nil
+}
+
+// ----------------------------------------------------------------------------
+// Env provider: ENCRYPTION_KEY (current) + ENCRYPTION_OLD_KEYS (read-only)
+// ----------------------------------------------------------------------------
+
+// newEnvKeyProvider builds a keyring from the current key and a
+// "version=key,version=key" list of previous keys still accepted on read
+func newEnvKeyProvider(version, key, oldKeys string) (*keyring, error) {
+	encoded := map[string]string{version: key}
+	for _, pair := range strings.Split(oldKeys, ",") {
+		v, k, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || v == "" {
+			continue
+		}
+		if v == version {
+			return nil, fmt.Errorf("old key version %q duplicates current version", v)
+		}
+		encoded[v] = k
+	}
+	return newKeyring(version, encoded)
+}
+
+// ----------------------------------------------------------------------------
+// File keyring: JSON file with several versions
+// ----------------------------------------------------------------------------
+
+// keyringFile is the on-disk format of KEYRING_FILE:
+//
+//	{"current": "v2", "keys": {"v1": "<base64>", "v2": "<base64>"}}
+type keyringFile struct {
+	Current string            `json:"current"`
+	Keys    map[string]string `json:"keys"`
+}
+
+// newFileKeyProvider loads and validates a keyring file
+func newFileKeyProvider(path string) (*keyring, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.Mode().Perm()&0o077 != 0 {
+		log.Printf("[SECURITY WARNING] Keyring %s is readable by group/others (mode %o); use chmod 600", path, info.Mode().Perm())
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var kf keyringFile
+	if err := json.Unmarshal(raw, &kf); err != nil {
+		return nil, fmt.Errorf("parse keyring: %w", err)
+	}
+	return newKeyring(kf.Current, kf.Keys)
+}
@@ -0,0 +1,734 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// ============================================================================
+// Key-value storage (AVAILABILITY): Redis or in-process memory
+// ============================================================================
+//
+// Everything that is not in PostgreSQL (data keys, caches, idempotency
+// records, nonces, and users/health records without a database) goes
+// through KVStore. The memory backend lets the full API run with no
+// external services; its data is lost on restart and not shared between
+// replicas.
+
+// errKeyNotFound is returned by Get/GetDel for missing or expired keys
+var errKeyNotFound = errors.New("key not found")
+
+// errKeyChanged is returned by Update when another writer got there first
+var errKeyChanged = errors.New("key changed concurrently")
+
+// errWrongType is returned when a string operation meets a list or vice versa
+var errWrongType = errors.New("operation against a key holding the wrong kind of value")
+
+// errInvalidCursor is returned by the memory Scan for an unknown or expired cursor
+var errInvalidCursor = errors.New("invalid or expired scan cursor")
+
+// KVStore is the subset of Redis semantics the server relies on.
+// A ttl of 0 means no expiry.
+type KVStore interface {
+	Get(ctx context.Context, key string) ([]byte, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// SetNX stores value only if key does not exist; reports whether it did
+	SetNX(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error)
+	// GetDel atomically reads and removes key
+	GetDel(ctx context.Context, key string) ([]byte, error)
+	// Del removes keys and returns how many existed
+	Del(ctx context.Context, keys ...string) (int64, error)
+	Expire(ctx context.Context, key string, ttl time.Duration) error
+	// TTL returns the remaining time to live (0 = no expiry); a missing key is errKeyNotFound
+	TTL(ctx context.Context, key string) (time.Duration, error)
+	// Persist removes key's expiry; reports whether it had one
+	Persist(ctx context.Context, key string) (bool, error)
+	// Update replaces key's value with fn(current), keeping its TTL, and fails
+	// with errKeyChanged if key was written meanwhile (optimistic, like WATCH).
+	// fn returning nil leaves the value as is; a missing key is errKeyNotFound.
+	Update(ctx context.Context, key string, fn func(current []byte) ([]byte, error)) error
+
+	LPush(ctx context.Context, key string, values ...string) error
+	// LRange returns the whole list, head first
+	LRange(ctx context.Context, key string) ([]string, error)
+	// LRem removes the first occurrence of value
+	LRem(ctx context.Context, key, value string) error
+	// UpdateList replaces a list with fn(current), like Update for strings
+	UpdateList(ctx context.Context, key string, fn func(current []string) ([]string, error)) error
+
+	// Scan iterates keys matching a glob pattern; cursor 0 starts and ends the iteration
+	Scan(ctx context.Context, cursor uint64, match string, count int64) ([]string, uint64, error)
+
+	Ping(ctx context.Context) error
+	Close() error
+}
+
+// kv is the process-wide store (see initStorage)
+var kv KVStore
+
+// ----------------------------------------------------------------------------
+// Redis
+// ----------------------------------------------------------------------------
+
+// redisKVStore works with a single node, Sentinel or Cluster client and
+// prepends prefix to every key (callers never see it)
+type redisKVStore struct {
+	client redis.UniversalClient
+	prefix string
+}
+
+func newRedisKVStore(client redis.UniversalClient, prefix string) *redisKVStore {
+	return &redisKVStore{client: client, prefix: prefix}
+}
+
+// redisErr maps redis.Nil to errKeyNotFound
+func redisErr(err error) error {
+	if err == redis.Nil {
+		return errKeyNotFound
+	}
+	return err
+}
+
+func (s *redisKVStore) k(key string) string {
+	return s.prefix + key
+}
+
+func (s *redisKVStore) Get(ctx context.Context, key string) ([]byte, error) {
+	v, err := s.client.Get(ctx, s.k(key)).Bytes()
+	return v, redisErr(err)
+}
+
+func (s *redisKVStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return s.client.Set(ctx, s.k(key), value, ttl).Err()
+}
+
+func (s *redisKVStore) SetNX(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	return s.client.SetNX(ctx, s.k(key), value, ttl).Result()
+}
+
+// GetDel uses GETDEL (Redis >= 6.2)
+func (s *redisKVStore) GetDel(ctx context.Context, key string) ([]byte, error) {
+	v, err := s.client.GetDel(ctx, s.k(key)).Bytes()
+	return v, redisErr(err)
+}
+
+// Del pipelines one DEL per key: a multi-key DEL fails in Cluster mode when
+// the keys hash to different slots
+func (s *redisKVStore) Del(ctx context.Context, keys ...string) (int64, error) {
+	if len(keys) == 0 {
+		return 0, nil
+	}
+	cmds := make([]*redis.IntCmd, len(keys))
+	_, err := s.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			cmds[i] = pipe.Del(ctx, s.k(key))
+		}
+		return nil
+	})
+	var n int64
+	for _, cmd := range cmds {
+		n += cmd.Val()
+	}
+	return n, err
+}
+
+func (s *redisKVStore) Expire(ctx context.Context, key string, ttl time.Duration) error {
+	return s.client.Expire(ctx, s.k(key), ttl).Err()
+}
+
+func (s *redisKVStore) TTL(ctx context.Context, key string) (time.Duration, error) {
+	ttl, err := s.client.PTTL(ctx, s.k(key)).Result()
+	switch {
+	case err != nil:
+		return 0, err
+	case ttl == -2: // go-redis passes the -2 (missing) and -1 (no expiry) replies through
+		return 0, errKeyNotFound
+	case ttl < 0:
+		return 0, nil
+	}
+	return ttl, nil
+}
+
+func (s *redisKVStore) Persist(ctx context.Context, key string) (bool, error) {
+	return s.client.Persist(ctx, s.k(key)).Result()
+}
+
+func (s *redisKVStore) Update(ctx context.Context, key string, fn func([]byte) ([]byte, error)) error {
+	full := s.k(key)
+	err := s.client.Watch(ctx, func(tx *redis.Tx) error {
+		current, err := tx.Get(ctx, full).Bytes()
+		if err != nil {
+			return redisErr(err)
+		}
+		next, err := fn(current)
+		if err != nil || next == nil {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, full, next, redis.KeepTTL)
+			return nil
+		})
+		return err
+	}, full)
+	if err == redis.TxFailedErr {
+		return errKeyChanged
+	}
+	return err
+}
+
+func (s *redisKVStore) LPush(ctx context.Context, key string, values ...string) error {
+	args := make([]interface{}, len(values))
+	for i, v := range values {
+		args[i] = v
+	}
+	return s.client.LPush(ctx, s.k(key), args...).Err()
+}
+
+func (s *redisKVStore) LRange(ctx context.Context, key string) ([]string, error) {
+	return s.client.LRange(ctx, s.k(key), 0, -1).Result()
+}
+
+func (s *redisKVStore) LRem(ctx context.Context, key, value string) error {
+	return s.client.LRem(ctx, s.k(key), 1, value).Err()
+}
+
+func (s *redisKVStore) UpdateList(ctx context.Context, key string, fn func([]string) ([]string, error)) error {
+	full := s.k(key)
+	err := s.client.Watch(ctx, func(tx *redis.Tx) error {
+		current, err := tx.LRange(ctx, full, 0, -1).Result()
+		if err != nil {
+			return redisErr(err)
+		}
+		if len(current) == 0 {
+			return errKeyNotFound
+		}
+		ttl, err := tx.PTTL(ctx, full).Result()
+		if err != nil {
+			return err
+		}
+		next, err := fn(current)
+		if err != nil || next == nil {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Del(ctx, full)
+			if len(next) > 0 {
+				args := make([]interface{}, len(next))
+				for i, v := range next {
+					args[i] = v
+				}
+				pipe.RPush(ctx, full, args...)
+				if ttl > 0 {
+					pipe.PExpire(ctx, full, ttl)
+				}
+			}
+			return nil
+		})
+		return err
+	}, full)
+	if err == redis.TxFailedErr {
+		return errKeyChanged
+	}
+	return err
+}
+
+// Scan strips the prefix from returned keys. In Cluster mode each master is
+// scanned in turn; the cursor's top 16 bits select the master.
+func (s *redisKVStore) Scan(ctx context.Context, cursor uint64, match string, count int64) ([]string, uint64, error) {
+	var keys []string
+	var next uint64
+	var err error
+	if cluster, ok := s.client.(*redis.ClusterClient); ok {
+		keys, next, err = scanCluster(ctx, cluster, cursor, s.k(match), count)
+	} else {
+		keys, next, err = s.client.Scan(ctx, cursor, s.k(match), count).Result()
+	}
+	for i, key := range keys {
+		keys[i] = strings.TrimPrefix(key, s.prefix)
+	}
+	return keys, next, err
+}
+
+const clusterCursorBits = 48
+
+func scanCluster(ctx context.Context, cluster *redis.ClusterClient, cursor uint64, match string, count int64) ([]string, uint64, error) {
+	var mu sync.Mutex
+	nodes := map[string]*redis.Client{}
+	err := cluster.ForEachMaster(ctx, func(ctx context.Context, node *redis.Client) error {
+		mu.Lock()
+		nodes[node.Options().Addr] = node
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	addrs := make([]string, 0, len(nodes))
+	for addr := range nodes {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+
+	index := cursor >> clusterCursorBits
+	if index >= uint64(len(addrs)) {
+		return nil, 0, nil
+	}
+	keys, next, err := nodes[addrs[index]].Scan(ctx, cursor&(1<<clusterCursorBits-1), match, count).Result()
+	if err != nil {
+		return nil, 0, err
+	}
+	if next == 0 {
+		index++
+		if index == uint64(len(addrs)) {
+			return keys, 0, nil
+		}
+	}
+	return keys, index<<clusterCursorBits | next, nil
+}
+
+func (s *redisKVStore) Ping(ctx context.Context) error {
+	return s.client.Ping(ctx).Err()
+}
+
+// MaxmemoryPolicy reads maxmemory_policy from INFO memory
+func (s *redisKVStore) MaxmemoryPolicy(ctx context.Context) (string, error) {
+	info, err := s.client.Info(ctx, "memory").Result()
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(info, "\n") {
+		if policy, ok := strings.CutPrefix(strings.TrimSpace(line), "maxmemory_policy:"); ok {
+			return policy, nil
+		}
+	}
+	return "", errors.New("maxmemory_policy not reported by INFO memory")
+}
+
+func (s *redisKVStore) Close() error {
+	return s.client.Close()
+}
+
+// ----------------------------------------------------------------------------
+// In-memory (single process)
+// ----------------------------------------------------------------------------
+
+type memoryEntry struct {
+	value     []byte
+	list      []string // non-nil for list keys
+	expiresAt time.Time
+}
+
+func (e *memoryEntry) expired(now time.Time) bool {
+	return !e.expiresAt.IsZero() && !now.Before(e.expiresAt)
+}
+
+type memoryKVStore struct {
+	mu         sync.Mutex
+	entries    map[string]*memoryEntry
+	cursors    map[uint64]memoryScanCursor
+	nextCursor uint64
+	done       chan struct{}
+}
+
+// memoryScanCursor resumes a Scan after the last key it returned, so keys
+// deleted or added meanwhile do not shift the iteration (as with Redis,
+// every key present throughout is returned)
+type memoryScanCursor struct {
+	after     string
+	createdAt time.Time
+}
+
+// memoryScanCursorTTL drops the cursors of abandoned iterations
+const memoryScanCursorTTL = 10 * time.Minute
+
+// newMemoryKVStore creates the store and starts a sweeper that drops expired
+// keys every interval (reads also ignore them, so TTLs are exact).
+func newMemoryKVStore(interval time.Duration) *memoryKVStore {
+	s := &memoryKVStore{
+		entries: make(map[string]*memoryEntry),
+		cursors: make(map[uint64]memoryScanCursor),
+		done:    make(chan struct{}),
+	}
+	go s.sweep(interval)
+	return s
+}
+
+// live returns the entry for key, dropping it if expired. Caller holds mu.
+func (s *memoryKVStore) live(key string) *memoryEntry {
+	e, ok := s.entries[key]
+	if !ok {
+		return nil
+	}
+	if e.expired(time.Now()) {
+		delete(s.entries, key)
+		return nil
+	}
+	return e
+}
+
+func expiry(ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(ttl)
+}
+
+func (s *memoryKVStore) Get(_ context.Context, key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := s.live(key)
+	if e == nil {
+		return nil, errKeyNotFound
+	}
+	if e.list != nil {
+		return nil, errWrongType
+	}
+	return append([]byte(nil), e.value...), nil
+}
+
+func (s *memoryKVStore) Set(_ context.Context, key string, value []byte, ttl time.Duration) error {
+	s.mu.Lock()
+	s.entries[key] = &memoryEntry{value: append([]byte(nil), value...), expiresAt: expiry(ttl)}
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *memoryKVStore) SetNX(_ context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.live(key) != nil {
+		return false, nil
+	}
+	s.entries[key] = &memoryEntry{value: append([]byte(nil), value...), expiresAt: expiry(ttl)}
+	return true, nil
+}
+
+func (s *memoryKVStore) GetDel(_ context.Context, key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := s.live(key)
+	if e == nil {
+		return nil, errKeyNotFound
+	}
+	if e.list != nil {
+		return nil, errWrongType
+	}
+	delete(s.entries, key)
+	return e.value, nil
+}
+
+func (s *memoryKVStore) Del(_ context.Context, keys ...string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var n int64
+	for _, key := range keys {
+		if s.live(key) != nil {
+			delete(s.entries, key)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (s *memoryKVStore) Expire(_ context.Context, key string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e := s.live(key); e != nil {
+		if ttl <= 0 {
+			delete(s.entries, key) // as Redis: a non-positive TTL deletes the key
+		} else {
+			e.expiresAt = expiry(ttl)
+		}
+	}
+	return nil
+}
+
+func (s *memoryKVStore) TTL(_ context.Context, key string) (time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := s.live(key)
+	if e == nil {
+		return 0, errKeyNotFound
+	}
+	if e.expiresAt.IsZero() {
+		return 0, nil
+	}
+	return time.Until(e.expiresAt), nil
+}
+
+func (s *memoryKVStore) Persist(_ context.Context, key string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := s.live(key)
+	if e == nil || e.expiresAt.IsZero() {
+		return false, nil
+	}
+	e.expiresAt = time.Time{}
+	return true, nil
+}
+
+// Update runs fn without holding the lock (fn may itself use the store, e.g.
+// to load a data key); values are replaced, never mutated, on write, so an
+// unchanged pointer means nobody else wrote the value.
+func (s *memoryKVStore) Update(_ context.Context, key string, fn func([]byte) ([]byte, error)) error {
+	s.mu.Lock()
+	e := s.live(key)
+	s.mu.Unlock()
+	if e == nil {
+		return errKeyNotFound
+	}
+	if e.list != nil {
+		return errWrongType
+	}
+	next, err := fn(append([]byte(nil), e.value...))
+	if err != nil || next == nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.live(key) != e {
+		return errKeyChanged
+	}
+	s.entries[key] = &memoryEntry{value: append([]byte(nil), next...), expiresAt: e.expiresAt}
+	return nil
+}
+
+func (s *memoryKVStore) LPush(_ context.Context, key string, values ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := s.live(key)
+	if e == nil {
+		e = &memoryEntry{list: []string{}}
+		s.entries[key] = e
+	} else if e.list == nil {
+		return errWrongType
+	}
+	list := e.list
+	for _, v := range values {
+		list = append([]string{v}, list...)
+	}
+	s.entries[key] = &memoryEntry{list: list, expiresAt: e.expiresAt}
+	return nil
+}
+
+func (s *memoryKVStore) LRange(_ context.Context, key string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := s.live(key)
+	if e == nil {
+		return []string{}, nil
+	}
+	if e.list == nil {
+		return nil, errWrongType
+	}
+	return append([]string(nil), e.list...), nil
+}
+
+func (s *memoryKVStore) LRem(_ context.Context, key, value string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := s.live(key)
+	if e == nil {
+		return nil
+	}
+	if e.list == nil {
+		return errWrongType
+	}
+	for i, v := range e.list {
+		if v == value {
+			list := append(append([]string{}, e.list[:i]...), e.list[i+1:]...)
+			s.setList(key, list, e.expiresAt)
+			break
+		}
+	}
+	return nil
+}
+
+// UpdateList runs fn without holding the lock, as Update does
+func (s *memoryKVStore) UpdateList(_ context.Context, key string, fn func([]string) ([]string, error)) error {
+	s.mu.Lock()
+	e := s.live(key)
+	s.mu.Unlock()
+	if e == nil {
+		return errKeyNotFound
+	}
+	if e.list == nil {
+		return errWrongType
+	}
+	next, err := fn(append([]string(nil), e.list...))
+	if err != nil || next == nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.live(key) != e {
+		return errKeyChanged
+	}
+	s.setList(key, append([]string(nil), next...), e.expiresAt)
+	return nil
+}
+
+// setList replaces a list entry; Redis drops empty lists
+func (s *memoryKVStore) setList(key string, list []string, expiresAt time.Time) {
+	if len(list) == 0 {
+		delete(s.entries, key)
+		return
+	}
+	s.entries[key] = &memoryEntry{list: list, expiresAt: expiresAt}
+}
+
+// Scan walks the key set in sorted order; a cursor stands for the last key
+// returned (see memoryScanCursor)
+func (s *memoryKVStore) Scan(_ context.Context, cursor uint64, match string, count int64) ([]string, uint64, error) {
+	if count <= 0 {
+		count = 10
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	after := ""
+	if cursor != 0 {
+		c, ok := s.cursors[cursor]
+		if !ok {
+			return nil, 0, errInvalidCursor
+		}
+		delete(s.cursors, cursor)
+		after = c.after
+	}
+
+	now := time.Now()
+	var rest []string
+	for key, e := range s.entries {
+		if key > after && !e.expired(now) {
+			rest = append(rest, key)
+		}
+	}
+	sort.Strings(rest)
+
+	window, next := rest, uint64(0)
+	if int64(len(rest)) > count {
+		window = rest[:count]
+		s.nextCursor++
+		next = s.nextCursor
+		s.cursors[next] = memoryScanCursor{after: window[len(window)-1], createdAt: now}
+	}
+	var keys []string
+	for _, key := range window {
+		if globMatch(match, key) {
+			keys = append(keys, key)
+		}
+	}
+	return keys, next, nil
+}
+
+// globMatch matches key against a Redis glob: * and ? match any byte
+// (including '/'), [abc], [a-z] and [^...] are classes, \ escapes
+func globMatch(pattern, key string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(key); i++ {
+				if globMatch(pattern[1:], key[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if key == "" {
+				return false
+			}
+			pattern, key = pattern[1:], key[1:]
+		case '[':
+			if key == "" {
+				return false
+			}
+			i, negate, matched := 1, false, false
+			if i < len(pattern) && pattern[i] == '^' {
+				negate = true
+				i++
+			}
+			for i < len(pattern) && pattern[i] != ']' {
+				lo := pattern[i]
+				if lo == '\\' && i+1 < len(pattern) {
+					i++
+					lo = pattern[i]
+				}
+				hi := lo
+				if i+2 < len(pattern) && pattern[i+1] == '-' && pattern[i+2] != ']' {
+					hi = pattern[i+2]
+					i += 2
+					if lo > hi {
+						lo, hi = hi, lo
+					}
+				}
+				if lo <= key[0] && key[0] <= hi {
+					matched = true
+				}
+				i++
+			}
+			if matched == negate {
+				return false
+			}
+			if i < len(pattern) {
+				i++ // the closing ]
+			}
+			pattern, key = pattern[i:], key[1:]
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if key == "" || key[0] != pattern[0] {
+				return false
+			}
+			pattern, key = pattern[1:], key[1:]
+		}
+	}
+	return key == ""
+}
+
+func (s *memoryKVStore) Ping(context.Context) error { return nil }
+
+// Close stops the background sweeper
+func (s *memoryKVStore) Close() error {
+	close(s.done)
+	return nil
+}
+
+func (s *memoryKVStore) sweep(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case now := <-ticker.C:
+			s.mu.Lock()
+			for key, e := range s.entries {
+				if e.expired(now) {
+					delete(s.entries, key)
+				}
+			}
+			for id, c := range s.cursors {
+				if now.Sub(c.createdAt) > memoryScanCursorTTL {
+					delete(s.cursors, id)
+				}
+			}
+			s.mu.Unlock()
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"log"
+	"sync/atomic"
+)
+
+// ============================================================================
+// Log levels (log.level in config, SIGHUP-reloadable)
+// ============================================================================
+//
+// Startup and status lines go through infof, degraded-but-working conditions
+// through warnf. Errors, [AUDIT] lines and security events (attacks, secret
+// rotation, [SECURITY WARNING]) use log directly and are always written.
+
+const (
+	levelDebug int32 = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+var logLevels = map[string]int32{
+	"debug": levelDebug,
+	"info":  levelInfo,
+	"warn":  levelWarn,
+	"error": levelError,
+}
+
+var currentLogLevel atomic.Int32
+
+func init() {
+	currentLogLevel.Store(levelInfo)
+}
+
+// setLogLevel applies a config level name; unknown names are rejected by config validation
+func setLogLevel(name string) {
+	if level, ok := logLevels[name]; ok {
+		currentLogLevel.Store(level)
+	}
+}
+
+// debugf logs only at log level "debug"
+func debugf(format string, args ...interface{}) {
+	if currentLogLevel.Load() <= levelDebug {
+		log.Printf("[DEBUG] "+format, args...)
+	}
+}
+
+// infof logs at "info" and below
+func infof(format string, args ...interface{}) {
+	if currentLogLevel.Load() <= levelInfo {
+		log.Printf(format, args...)
+	}
+}
+
+// warnf logs at "warn" and below
+func warnf(format string, args ...interface{}) {
+	if currentLogLevel.Load() <= levelWarn {
+		log.Printf(format, args...)
+	}
+}
@@ -0,0 +1,14 @@
+-- Users: email is stored only as a blind index (HMAC) and DEK-encrypted text
+-- IF NOT EXISTS: databases created before migrations existed already have it
+CREATE TABLE IF NOT EXISTS users (
+	id            UUID PRIMARY KEY,
+	email_index   TEXT NOT NULL,
+	email_enc     TEXT NOT NULL,
+	password_hash TEXT NOT NULL,
+	full_name     TEXT NOT NULL,
+	role          TEXT NOT NULL DEFAULT 'user',
+	active        BOOLEAN NOT NULL DEFAULT TRUE,
+	created_at    TIMESTAMPTZ NOT NULL,
+	updated_at    TIMESTAMPTZ NOT NULL,
+	CONSTRAINT users_email_index_key UNIQUE (email_index)
+);
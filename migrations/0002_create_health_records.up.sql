@@ -0,0 +1,19 @@
+-- Health records: notes (and optionally values) are DEK-encrypted
+-- IF NOT EXISTS: databases created before migrations existed already have it
+CREATE TABLE IF NOT EXISTS health_records (
+	id          UUID PRIMARY KEY,
+	user_id     TEXT NOT NULL,
+	type        TEXT NOT NULL,
+	value       DOUBLE PRECISION,
+	value_enc   TEXT,
+	unit        TEXT NOT NULL,
+	notes       TEXT NOT NULL,
+	recorded_at TIMESTAMPTZ NOT NULL,
+	created_at  TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS health_records_user_type_recorded_idx
+	ON health_records (user_id, type, recorded_at DESC);
+
+CREATE INDEX IF NOT EXISTS health_records_user_recorded_idx
+	ON health_records (user_id, recorded_at DESC);
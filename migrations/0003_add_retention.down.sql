@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS health_records_type_recorded_idx;
+
+ALTER TABLE users DROP COLUMN IF EXISTS legal_hold;
@@ -0,0 +1,6 @@
+-- Retention (retention.go): legal holds exempt a user's data from purges,
+-- and purges select records by type and age across all users
+ALTER TABLE users ADD COLUMN IF NOT EXISTS legal_hold BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE INDEX IF NOT EXISTS health_records_type_recorded_idx
+	ON health_records (type, recorded_at);
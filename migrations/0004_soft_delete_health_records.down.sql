@@ -0,0 +1,6 @@
+-- Records still marked deleted would reappear: remove them first
+DELETE FROM health_records WHERE deleted_at IS NOT NULL;
+
+DROP INDEX IF EXISTS health_records_deleted_idx;
+
+ALTER TABLE health_records DROP COLUMN IF EXISTS deleted_at;
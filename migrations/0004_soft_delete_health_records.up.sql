@@ -0,0 +1,6 @@
+-- Soft delete: deleted records are hidden until restored or swept
+-- (retention.restore_window); the partial index serves the sweeper
+ALTER TABLE health_records ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS health_records_deleted_idx
+	ON health_records (deleted_at) WHERE deleted_at IS NOT NULL;
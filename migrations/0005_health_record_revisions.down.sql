@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS health_record_revisions;
+
+ALTER TABLE health_records DROP COLUMN IF EXISTS updated_by;
+ALTER TABLE health_records DROP COLUMN IF EXISTS updated_at;
+ALTER TABLE health_records DROP COLUMN IF EXISTS version;
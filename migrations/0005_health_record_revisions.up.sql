@@ -0,0 +1,21 @@
+-- Record versions: the current version stays in health_records, replaced
+-- versions move to health_record_revisions (encrypted like the record)
+ALTER TABLE health_records ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE health_records ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ;
+ALTER TABLE health_records ADD COLUMN IF NOT EXISTS updated_by TEXT;
+
+-- changed_by/changed_at: who wrote that version and when
+CREATE TABLE IF NOT EXISTS health_record_revisions (
+	record_id   UUID NOT NULL REFERENCES health_records (id) ON DELETE CASCADE,
+	version     INTEGER NOT NULL,
+	user_id     TEXT NOT NULL,
+	type        TEXT NOT NULL,
+	value       DOUBLE PRECISION,
+	value_enc   TEXT,
+	unit        TEXT NOT NULL,
+	notes       TEXT NOT NULL,
+	recorded_at TIMESTAMPTZ NOT NULL,
+	changed_by  TEXT NOT NULL,
+	changed_at  TIMESTAMPTZ NOT NULL,
+	PRIMARY KEY (record_id, version)
+);
@@ -0,0 +1,11 @@
+-- Per-user audit trail (who did what to an account and its records), part
+-- of the user's data export; removed with the account on erasure
+CREATE TABLE IF NOT EXISTS audit_log (
+	id         BIGSERIAL PRIMARY KEY,
+	user_id    TEXT NOT NULL,
+	action     TEXT NOT NULL,
+	detail     TEXT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS audit_log_user_idx ON audit_log (user_id, id);
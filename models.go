@@ -12,28 +12,38 @@ type User struct {
 	Email     string    `json:"email"`
 	Password  string    `json:"-"` // Never expose password in JSON
 	FullName  string    `json:"full_name"`
+	Role      string    `json:"role,omitempty"` // roleUser or roleAdmin
 	Active    bool      `json:"active"`
+	LegalHold bool      `json:"legal_hold,omitempty"` // exempt from retention purges and erasure
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 }
 
+// User roles (admins are created with `server user create-admin`)
+const (
+	roleUser  = "user"
+	roleAdmin = "admin"
+)
+
 // RegisterRequest is the payload for user registration
 type RegisterRequest struct {
 	Email    string `json:"email" validate:"required,email"`
 	Password string `json:"password" validate:"required,min=8"`
 	FullName string `json:"full_name" validate:"required,min=3"`
+	Session  string `json:"session" validate:"omitempty,oneof=token cookie"` // "cookie" for browser sessions
 }
 
 // LoginRequest is the payload for user login
 type LoginRequest struct {
 	Email    string `json:"email" validate:"required,email"`
 	Password string `json:"password" validate:"required"`
+	Session  string `json:"session" validate:"omitempty,oneof=token cookie"` // "cookie" for browser sessions
 }
 
 // AuthResponse is returned after successful login/register
 type AuthResponse struct {
-	Token     string `json:"token"`
-	ExpiresIn int    `json:"expires_in"` // seconds
+	Token     string `json:"token,omitempty"` // omitted for cookie sessions
+	ExpiresIn int    `json:"expires_in"`      // seconds
 	User      *User  `json:"user"`
 }
 
@@ -41,16 +51,62 @@ type AuthResponse struct {
 // Health Data Models
 // ============================================================================
 
+// healthRecordTypes lists the supported metric types (keep in sync with validate tags)
+var healthRecordTypes = []string{"blood_pressure", "heart_rate", "weight", "temperature", "glucose"}
+
 // HealthRecord represents a single health measurement record
 type HealthRecord struct {
-	ID         string    `json:"id"`
-	UserID     string    `json:"user_id"`
-	Type       string    `json:"type"` // "blood_pressure", "heart_rate", "weight", "temperature", "glucose"
+	ID         string     `json:"id"`
+	UserID     string     `json:"user_id"`
+	Type       string     `json:"type"` // "blood_pressure", "heart_rate", "weight", "temperature", "glucose"
+	Value      float64    `json:"value"`
+	Unit       string     `json:"unit"` // "mmHg", "bpm", "kg", "°C", "mg/dL"
+	Notes      string     `json:"notes"`
+	RecordedAt time.Time  `json:"recorded_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+	Version    int        `json:"version"`              // 1 when created, +1 per update
+	UpdatedAt  *time.Time `json:"updated_at,omitempty"` // last update (nil = never updated)
+	UpdatedBy  string     `json:"updated_by,omitempty"` // principal of the last update
+	DeletedAt  *time.Time `json:"deleted_at,omitempty"` // soft-deleted: restorable until swept
+}
+
+// HealthRecordRevision is one version of a record (GET /api/v1/health/{id}/history)
+type HealthRecordRevision struct {
+	Version    int       `json:"version"`
+	Type       string    `json:"type"`
 	Value      float64   `json:"value"`
-	Unit       string    `json:"unit"` // "mmHg", "bpm", "kg", "°C", "mg/dL"
+	Unit       string    `json:"unit"`
 	Notes      string    `json:"notes"`
 	RecordedAt time.Time `json:"recorded_at"`
-	CreatedAt  time.Time `json:"created_at"`
+	ChangedBy  string    `json:"changed_by"` // who wrote this version
+	ChangedAt  time.Time `json:"changed_at"`
+}
+
+// revisionOf describes the version held in rec
+func revisionOf(rec *HealthRecord) *HealthRecordRevision {
+	rev := &HealthRecordRevision{
+		Version:    rec.Version,
+		Type:       rec.Type,
+		Value:      rec.Value,
+		Unit:       rec.Unit,
+		Notes:      rec.Notes,
+		RecordedAt: rec.RecordedAt,
+		ChangedBy:  rec.UserID,
+		ChangedAt:  rec.CreatedAt,
+	}
+	if rec.UpdatedAt != nil {
+		rev.ChangedBy, rev.ChangedAt = rec.UpdatedBy, *rec.UpdatedAt
+	}
+	return rev
+}
+
+// HealthRecordPatch is the PATCH payload: only fields present are changed
+type HealthRecordPatch struct {
+	Type       *string  `json:"type"`
+	Value      *float64 `json:"value"`
+	Unit       *string  `json:"unit"`
+	Notes      *string  `json:"notes"`
+	RecordedAt *string  `json:"recorded_at"` // ISO 8601 format
 }
 
 // HealthRecordRequest is the payload for creating/updating health records
@@ -58,7 +114,7 @@ type HealthRecordRequest struct {
 	Type       string  `json:"type" validate:"required,oneof=blood_pressure heart_rate weight temperature glucose"`
 	Value      float64 `json:"value" validate:"required,min=0"`
 	Unit       string  `json:"unit" validate:"required"`
-	Notes      string  `json:"notes" validate:"max=500"`
+	Notes      string  `json:"notes" validate:"max=500,not_ciphertext"`
 	RecordedAt string  `json:"recorded_at"` // ISO 8601 format
 }
 
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// ============================================================================
+// Redis client factory: standalone, Sentinel (failover) or Cluster
+// ============================================================================
+
+// newRedisClient builds the client for redis.mode from config. Credentials
+// (redis.password, redis.sentinel_password) are secrets, see secrets.go.
+func newRedisClient(cfg RedisConfig) (redis.UniversalClient, error) {
+	tlsConfig, err := redisTLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	switch cfg.Mode {
+	case "sentinel":
+		infof("[STORE] Redis: Sentinel master %q via %d sentinel(s), db %d, TLS %v",
+			cfg.MasterName, len(cfg.Addrs), cfg.DB, tlsConfig != nil)
+		return redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:       cfg.MasterName,
+			SentinelAddrs:    cfg.Addrs,
+			SentinelUsername: cfg.SentinelUsername,
+			SentinelPassword: cfg.SentinelPassword,
+			Username:         cfg.Username,
+			Password:         cfg.Password,
+			DB:               cfg.DB,
+			DialTimeout:      cfg.DialTimeout.D(),
+			ReadTimeout:      cfg.ReadTimeout.D(),
+			WriteTimeout:     cfg.WriteTimeout.D(),
+			PoolSize:         cfg.PoolSize,
+			MinIdleConns:     cfg.MinIdleConns,
+			TLSConfig:        tlsConfig,
+		}), nil
+
+	case "cluster":
+		if cfg.DB != 0 {
+			return nil, fmt.Errorf("redis.db must be 0 in cluster mode")
+		}
+		infof("[STORE] Redis: Cluster via %d seed node(s), TLS %v", len(cfg.Addrs), tlsConfig != nil)
+		return redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:        cfg.Addrs,
+			Username:     cfg.Username,
+			Password:     cfg.Password,
+			DialTimeout:  cfg.DialTimeout.D(),
+			ReadTimeout:  cfg.ReadTimeout.D(),
+			WriteTimeout: cfg.WriteTimeout.D(),
+			PoolSize:     cfg.PoolSize,
+			MinIdleConns: cfg.MinIdleConns,
+			TLSConfig:    tlsConfig,
+		}), nil
+
+	default:
+		infof("[STORE] Redis: %s, db %d, TLS %v", cfg.Addr, cfg.DB, tlsConfig != nil)
+		return redis.NewClient(&redis.Options{
+			Addr:         cfg.Addr,
+			Username:     cfg.Username,
+			Password:     cfg.Password,
+			DB:           cfg.DB,
+			DialTimeout:  cfg.DialTimeout.D(),
+			ReadTimeout:  cfg.ReadTimeout.D(),
+			WriteTimeout: cfg.WriteTimeout.D(),
+			PoolSize:     cfg.PoolSize,
+			MinIdleConns: cfg.MinIdleConns,
+			TLSConfig:    tlsConfig,
+		}), nil
+	}
+}
+
+// redisTLSConfig returns nil unless redis.tls is set. Without a CA file the
+// system roots are used.
+func redisTLSConfig(cfg RedisConfig) (*tls.Config, error) {
+	if !cfg.TLS {
+		return nil, nil
+	}
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: cfg.TLSServerName,
+	}
+	if cfg.TLSCAFile != "" {
+		pem, err := os.ReadFile(cfg.TLSCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("redis CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("redis CA file %s: no certificates found", cfg.TLSCAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	return tlsConfig, nil
+}
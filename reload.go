@@ -0,0 +1,178 @@
+package main
+
+import (
+	"log"
+	"maps"
+	"net/http"
+	"os"
+	"os/signal"
+	"reflect"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/go-chi/httprate"
+)
+
+// ============================================================================
+// AVAILABILITY: Live Configuration Reload (SIGHUP)
+// ============================================================================
+//
+// `kill -HUP <pid>` re-reads the config file, environment and the original
+// flags. Settings listed in reloadableConfigKeys are swapped atomically; any
+// other change is logged as requiring a restart and ignored until then.
+// An invalid new configuration is rejected as a whole.
+
+// reloadableConfigKeys are the config paths applied without a restart
+var reloadableConfigKeys = map[string]bool{
+	"security.allowed_origins":            true,
+	"security.redirect_host":              true,
+	"security.redirect_allowed_hosts":     true,
+	"rate_limit.requests_per_minute":      true,
+	"rate_limit.auth_requests_per_minute": true,
+	"log.level":                           true,
+}
+
+// liveSettings is the reloadable subset of Config, read per request
+type liveSettings struct {
+	AllowedOrigins       []string
+	RedirectHost         string
+	RedirectAllowedHosts map[string]bool
+	RateLimit            RateLimitConfig
+	LogLevel             string
+}
+
+var currentLiveSettings atomic.Pointer[liveSettings]
+
+// newLiveSettings extracts the reloadable settings from cfg
+func newLiveSettings(cfg *Config) *liveSettings {
+	s := &liveSettings{
+		AllowedOrigins:       slices.Clone(cfg.Security.AllowedOrigins),
+		RedirectHost:         cfg.Security.RedirectHost,
+		RedirectAllowedHosts: make(map[string]bool),
+		RateLimit:            cfg.RateLimit,
+		LogLevel:             cfg.Log.Level,
+	}
+	for _, host := range cfg.Security.RedirectAllowedHosts {
+		s.RedirectAllowedHosts[host] = true
+	}
+	return s
+}
+
+// applyLiveSettings publishes reloadable settings to the middleware
+func applyLiveSettings(cfg *Config) {
+	currentLiveSettings.Store(newLiveSettings(cfg))
+	setLogLevel(cfg.Log.Level)
+}
+
+// liveConfig returns the current reloadable settings (never nil after InitSecurityConfig)
+func liveConfig() *liveSettings {
+	return currentLiveSettings.Load()
+}
+
+// rateLimitByIP is httprate.LimitByIP with a limit read per request, so a
+// reload changes the tier without resetting the per-IP counters.
+func rateLimitByIP(limit func(*liveSettings) int) func(http.Handler) http.Handler {
+	limiter := httprate.NewRateLimiter(limit(liveConfig()), time.Minute, httprate.WithKeyFuncs(httprate.KeyByIP))
+	return func(next http.Handler) http.Handler {
+		limited := limiter.Handler(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := httprate.WithRequestLimit(r.Context(), limit(liveConfig()))
+			limited.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// watchConfigReload reloads the configuration on every SIGHUP.
+// running is the configuration the process was started with.
+func watchConfigReload(running *Config) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		reloadLiveConfig(running)
+	}
+}
+
+// reloadLiveConfig applies one reload; errors keep the current settings
+func reloadLiveConfig(running *Config) {
+	infof("[CONFIG] SIGHUP received, reloading configuration")
+	if reloadConfig == nil {
+		warnf("[CONFIG] Reload not available (configuration was not loaded from LoadConfig)")
+		return
+	}
+	next, err := reloadConfig()
+	if err != nil {
+		log.Printf("[CONFIG] Reload rejected, keeping current configuration: %v", err)
+		return
+	}
+
+	// Reloadable keys are compared with what is live now; the rest with the startup values
+	var applied, restart []string
+	current, updated := liveConfig(), newLiveSettings(next)
+	for _, key := range slices.Sorted(maps.Keys(reloadableConfigKeys)) {
+		if liveSettingChanged(current, updated, key) {
+			applied = append(applied, key)
+		}
+	}
+	for _, key := range diffConfig(running, next) {
+		if !reloadableConfigKeys[key] {
+			restart = append(restart, key)
+		}
+	}
+
+	applyLiveSettings(next)
+	if len(applied) > 0 {
+		infof("[CONFIG] Reloaded: %s", strings.Join(applied, ", "))
+	} else {
+		infof("[CONFIG] Reloaded: no reloadable settings changed")
+	}
+	for _, key := range restart {
+		log.Printf("[CONFIG WARNING] %s changed; restart required to apply it (still using the startup value)", key)
+	}
+}
+
+// liveSettingChanged reports whether a reloadable key differs between two snapshots
+func liveSettingChanged(old, next *liveSettings, key string) bool {
+	switch key {
+	case "security.allowed_origins":
+		return !slices.Equal(old.AllowedOrigins, next.AllowedOrigins)
+	case "security.redirect_host":
+		return old.RedirectHost != next.RedirectHost
+	case "security.redirect_allowed_hosts":
+		return !reflect.DeepEqual(old.RedirectAllowedHosts, next.RedirectAllowedHosts)
+	case "rate_limit.requests_per_minute":
+		return old.RateLimit.RequestsPerMinute != next.RateLimit.RequestsPerMinute
+	case "rate_limit.auth_requests_per_minute":
+		return old.RateLimit.AuthRequestsPerMinute != next.RateLimit.AuthRequestsPerMinute
+	case "log.level":
+		return old.LogLevel != next.LogLevel
+	}
+	return false
+}
+
+// diffConfig returns the dotted JSON paths (e.g. "server.addr") that differ
+func diffConfig(a, b *Config) []string {
+	var changed []string
+	var walk func(prefix string, va, vb reflect.Value)
+	walk = func(prefix string, va, vb reflect.Value) {
+		t := va.Type()
+		for i := 0; i < t.NumField(); i++ {
+			name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+			if prefix != "" {
+				name = prefix + "." + name
+			}
+			fa, fb := va.Field(i), vb.Field(i)
+			if fa.Kind() == reflect.Struct {
+				walk(name, fa, fb)
+				continue
+			}
+			if !reflect.DeepEqual(fa.Interface(), fb.Interface()) {
+				changed = append(changed, name)
+			}
+		}
+	}
+	walk("", reflect.ValueOf(a).Elem(), reflect.ValueOf(b).Elem())
+	return changed
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ============================================================================
+// Data retention: per-type periods, legal holds, scheduled purge
+// ============================================================================
+//
+// - retention.rules sets a period per record type (retention.default for the
+//   rest); "forever" keeps records indefinitely
+// - Records of users under legal hold (`server user legal-hold`) are never
+//   purged, and those users cannot erase their account
+// - The purge runs every retention.interval in `serve` (one replica per
+//   interval, via a KV lock) or on demand with `server retention purge`;
+//   what it deleted is written to the audit log
+// - A dry run (--dry-run, retention.dry_run) only reports
+// - Deleted records stay restorable for retention.restore_window; a sweeper
+//   (every retention.sweep_interval) then removes them, legal holds excepted
+
+// retentionForever keeps records indefinitely
+const retentionForever = "forever"
+
+// retentionLockKey stops several replicas from purging in the same interval
+const retentionLockKey = "retention:lock"
+
+// retentionSettings are the process-wide settings (see initRetention)
+var retentionSettings = RetentionConfig{Default: retentionForever, RestoreWindow: Duration(7 * 24 * time.Hour)}
+
+// initRetention publishes the settings read by handlers (restore window)
+func initRetention(cfg RetentionConfig) {
+	retentionSettings = cfg
+}
+
+// parseRetentionPeriod parses "forever" (or empty), "Nd", "Ny" or a Go
+// duration; 0 means keep forever
+func parseRetentionPeriod(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || s == retentionForever {
+		return 0, nil
+	}
+	var period time.Duration
+	switch unit := s[len(s)-1]; unit {
+	case 'd', 'y':
+		n, err := strconv.Atoi(s[:len(s)-1])
+		if err != nil {
+			return 0, fmt.Errorf("invalid retention period %q", s)
+		}
+		period = time.Duration(n) * 24 * time.Hour
+		if unit == 'y' {
+			period *= 365
+		}
+	default:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return 0, fmt.Errorf("invalid retention period %q", s)
+		}
+		period = d
+	}
+	if period <= 0 {
+		return 0, fmt.Errorf("retention period %q must be positive (or %q)", s, retentionForever)
+	}
+	return period, nil
+}
+
+// retentionPeriod returns the configured period for a record type
+func (c RetentionConfig) retentionPeriod(recordType string) string {
+	if period, ok := c.Rules[recordType]; ok {
+		return period
+	}
+	if c.Default == "" {
+		return retentionForever
+	}
+	return c.Default
+}
+
+// retentionReport describes one purge run (printed by `retention purge`)
+type retentionReport struct {
+	DryRun    bool                  `json:"dry_run"`
+	StartedAt time.Time             `json:"started_at"`
+	HeldUsers int                   `json:"held_users"` // exempt from the purge
+	Deleted   int                   `json:"deleted"`    // records (would be) deleted
+	Users     int                   `json:"users"`      // users affected
+	Types     []retentionTypeReport `json:"types"`
+}
+
+type retentionTypeReport struct {
+	Type    string     `json:"type"`
+	Period  string     `json:"period"`
+	Cutoff  *time.Time `json:"cutoff,omitempty"` // records recorded before this are purged; nil = kept forever
+	Records int        `json:"records"`
+	Users   int        `json:"users"`
+	Error   string     `json:"error,omitempty"`
+}
+
+// purgeExpiredRecords applies the retention rules once. A failing type is
+// reported and the others still run; the first error is returned.
+func purgeExpiredRecords(ctx context.Context, cfg RetentionConfig, dryRun bool) (*retentionReport, error) {
+	report := &retentionReport{DryRun: dryRun, StartedAt: time.Now().UTC()}
+	held, err := userStore.LegalHolds(ctx)
+	if err != nil {
+		return report, fmt.Errorf("cannot read legal holds: %w", err)
+	}
+	report.HeldUsers = len(held)
+
+	var firstErr error
+	affected := make(map[string][]string) // user -> "type=n" parts
+	for _, recordType := range healthRecordTypes {
+		entry := retentionTypeReport{Type: recordType, Period: cfg.retentionPeriod(recordType)}
+		period, _ := parseRetentionPeriod(entry.Period) // validated with the config
+		if period == 0 {
+			report.Types = append(report.Types, entry)
+			continue
+		}
+		cutoff := report.StartedAt.Add(-period)
+		entry.Cutoff = &cutoff
+
+		purged, err := healthStore.Purge(ctx, recordType, cutoff, held, dryRun)
+		if err != nil {
+			entry.Error = err.Error()
+			if firstErr == nil {
+				firstErr = fmt.Errorf("%s: %w", recordType, err)
+			}
+		}
+		for userID, n := range purged {
+			entry.Records += n
+			entry.Users++
+			affected[userID] = append(affected[userID], fmt.Sprintf("%s=%d", recordType, n))
+			if !dryRun {
+				healthRecordsChanged(ctx, userID, recordType)
+			}
+		}
+		report.Deleted += entry.Records
+		report.Types = append(report.Types, entry)
+	}
+	report.Users = len(affected)
+	if !dryRun {
+		for userID, parts := range affected {
+			auditEvent(ctx, userID, auditRecordsPurged, "Retention purge: deleted records of %s (%s)", userID, strings.Join(parts, " "))
+		}
+	}
+	logRetentionReport(report)
+	return report, firstErr
+}
+
+// logRetentionReport writes the summary; only real deletions are audited
+// (each affected user's trail gets its own entry, see purgeExpiredRecords)
+func logRetentionReport(report *retentionReport) {
+	var parts []string
+	for _, t := range report.Types {
+		if t.Records > 0 {
+			parts = append(parts, fmt.Sprintf("%s=%d", t.Type, t.Records))
+		}
+	}
+	detail := ""
+	if len(parts) > 0 {
+		detail = " (" + strings.Join(parts, " ") + ")"
+	}
+	if report.DryRun {
+		infof("[RETENTION] Dry run: would delete %d record(s) of %d user(s)%s, %d user(s) on legal hold",
+			report.Deleted, report.Users, detail, report.HeldUsers)
+		return
+	}
+	log.Printf("[AUDIT] Retention purge: deleted %d record(s) of %d user(s)%s, %d user(s) on legal hold",
+		report.Deleted, report.Users, detail, report.HeldUsers)
+}
+
+// watchRetention purges every interval (started by serve when
+// retention.interval > 0). The KV lock expires shortly before the next run,
+// so with shared storage only one replica purges per interval.
+func watchRetention(cfg RetentionConfig) {
+	interval := cfg.Interval.D()
+	infof("[RETENTION] Purge every %v (dry run %v): %s", interval, cfg.DryRun, retentionRulesSummary(cfg))
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		ctx := context.Background()
+		acquired, err := kv.SetNX(ctx, retentionLockKey, []byte(time.Now().UTC().Format(time.RFC3339)), interval*9/10)
+		if err != nil {
+			log.Printf("[RETENTION] Cannot take the purge lock: %v", err)
+			continue
+		}
+		if !acquired {
+			continue // another replica ran this interval
+		}
+		if _, err := purgeExpiredRecords(ctx, cfg, cfg.DryRun); err != nil {
+			log.Printf("[RETENTION] Purge incomplete: %v", err)
+		}
+	}
+}
+
+// sweepDeletedRecords removes records deleted longer than the restore window ago
+func sweepDeletedRecords(ctx context.Context, cfg RetentionConfig) (int, error) {
+	held, err := userStore.LegalHolds(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("cannot read legal holds: %w", err)
+	}
+	purged, err := healthStore.PurgeDeleted(ctx, time.Now().Add(-cfg.RestoreWindow.D()), held)
+	total := 0
+	for _, n := range purged {
+		total += n
+	}
+	for userID, n := range purged {
+		auditEvent(ctx, userID, auditDeletedSwept, "Deleted records swept: %d record(s) of %s past the %v restore window",
+			n, userID, cfg.RestoreWindow.D())
+	}
+	if total > 0 {
+		log.Printf("[AUDIT] Deleted records swept: %d record(s) of %d user(s) past the %v restore window",
+			total, len(purged), cfg.RestoreWindow.D())
+	}
+	return total, err
+}
+
+// watchDeletedRecords sweeps every retention.sweep_interval (started by
+// serve). Sweeps are idempotent, so replicas need no lock.
+func watchDeletedRecords(cfg RetentionConfig) {
+	ticker := time.NewTicker(cfg.SweepInterval.D())
+	defer ticker.Stop()
+	for range ticker.C {
+		if _, err := sweepDeletedRecords(context.Background(), cfg); err != nil {
+			log.Printf("[RETENTION] Sweep of deleted records failed: %v", err)
+		}
+	}
+}
+
+// runRetentionPurge implements `server retention purge [--dry-run]`: one
+// purge (and sweep of deleted records) now, with the JSON report on stdout
+func runRetentionPurge(args []string) {
+	fs := flag.NewFlagSet("retention purge", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "report what would be deleted without deleting")
+	cfg := loadCommandConfig(fs, args)
+
+	ctx, _ := initCommand(cfg)
+	report, err := purgeExpiredRecords(ctx, cfg.Retention, *dryRun)
+	if err == nil && !*dryRun {
+		_, err = sweepDeletedRecords(ctx, cfg.Retention)
+	}
+	if report != nil {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(report)
+	}
+	if err != nil {
+		log.Fatalf("[RETENTION] %v", err)
+	}
+}
+
+// retentionRulesSummary is the startup log form of the rules
+func retentionRulesSummary(cfg RetentionConfig) string {
+	var parts []string
+	for _, recordType := range healthRecordTypes {
+		parts = append(parts, recordType+"="+cfg.retentionPeriod(recordType))
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,476 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// ============================================================================
+// CONFIDENTIALITY: Master Key Rotation (keys rotate command)
+// ============================================================================
+//
+// Procedure:
+//  1. Add the new key as current, keep the old one readable
+//     (keyring "current" or ENCRYPTION_KEY + ENCRYPTION_OLD_KEYS) and restart.
+//     New writes now use the new version; reads accept both.
+//  2. Run `server keys rotate`. It walks user:* and health:* with SCAN (and
+//     the data keys in the separate keystore, if configured), re-wraps data
+//     keys and re-encrypts master-key/plaintext fields (history lists
+//     included); with a database it
+//     then does the same for the users, health_records and
+//     health_record_revisions rows, in primary-key order.
+//  3. Once it reports 0 failures, remove the old key.
+//
+// Every step skips values already on the current version, and the SCAN cursor
+// (or last row key) is checkpointed per batch, so an interrupted run can
+// simply be restarted.
+
+type rotationOutcome int
+
+const (
+	rotationSkipped rotationOutcome = iota
+	rotationRewrapped
+	rotationResealed
+)
+
+// rotationStats is the progress report
+type rotationStats struct {
+	Scanned   int
+	Rewrapped int
+	Resealed  int
+	Skipped   int
+	Failed    int
+}
+
+// runRotateKeys implements `server keys rotate [-batch N] [-restart] [--config file] [--redis-addr addr]`
+func runRotateKeys(args []string) {
+	fs := flag.NewFlagSet("keys rotate", flag.ExitOnError)
+	batch := fs.Int64("batch", 500, "keys per SCAN batch")
+	restart := fs.Bool("restart", false, "ignore saved progress and start from the beginning")
+	cfg := loadCommandConfig(fs, args)
+
+	InitSecurityConfig(cfg)
+	if keyProvider == nil {
+		log.Fatal("[ROTATE] No encryption key configured (set ENCRYPTION_KEY or KEYRING_FILE)")
+	}
+	if err := initStorage(cfg); err != nil {
+		log.Fatalf("[ROTATE] %v", err)
+	}
+	db := openDB(cfg.Database)
+
+	version, _, _ := keyProvider.CurrentKEK()
+	log.Printf("[ROTATE] Re-encrypting under key version %s (batch %d)", version, *batch)
+
+	ctx := context.Background()
+	stats := &rotationStats{}
+	rotatePattern(ctx, kv, version, "user:*", *batch, *restart, rotateUserKey, stats)
+	if separateKeystore {
+		rotatePattern(ctx, dekStore, version, "user:*:dek", *batch, *restart, rotateUserKey, stats)
+	}
+	rotatePattern(ctx, kv, version, "health:*", *batch, *restart, rotateHealthRecord, stats)
+	if db != nil {
+		for _, table := range postgresRotationTables {
+			rotateTable(ctx, db, version, table, *batch, *restart, stats)
+		}
+	}
+
+	log.Printf("[ROTATE] Done: scanned=%d rewrapped=%d resealed=%d skipped=%d failed=%d",
+		stats.Scanned, stats.Rewrapped, stats.Resealed, stats.Skipped, stats.Failed)
+	if stats.Failed > 0 {
+		log.Fatal("[ROTATE] Some keys failed; fix the errors above and run again (completed keys are skipped)")
+	}
+}
+
+// rotatePattern SCANs keys of store matching pattern and applies handle to
+// each, checkpointing the cursor (in kv) after every batch.
+func rotatePattern(ctx context.Context, store KVStore, version, pattern string, batch int64, restart bool,
+	handle func(context.Context, KVStore, string) (rotationOutcome, error), stats *rotationStats) {
+	cursorKey := "keys:rotate:" + version + ":cursor:" + pattern
+
+	var cursor uint64
+	if !restart {
+		if saved, err := kv.Get(ctx, cursorKey); err == nil {
+			cursor, _ = strconv.ParseUint(string(saved), 10, 64)
+			log.Printf("[ROTATE] Resuming %s from cursor %d", pattern, cursor)
+		}
+	}
+
+	for {
+		keys, next, err := store.Scan(ctx, cursor, pattern, batch)
+		if err != nil {
+			log.Fatalf("[ROTATE] SCAN %s failed: %v", pattern, err)
+		}
+		for _, key := range keys {
+			stats.Scanned++
+			outcome, err := handle(ctx, store, key)
+			switch {
+			case err != nil:
+				stats.Failed++
+				log.Printf("[ROTATE] %s: %v", key, err)
+			case outcome == rotationRewrapped:
+				stats.Rewrapped++
+			case outcome == rotationResealed:
+				stats.Resealed++
+			default:
+				stats.Skipped++
+			}
+		}
+
+		if next == 0 {
+			kv.Del(ctx, cursorKey)
+			break
+		}
+		kv.Set(ctx, cursorKey, []byte(strconv.FormatUint(next, 10)), 0)
+		cursor = next
+		log.Printf("[ROTATE] %s progress: scanned=%d rewrapped=%d resealed=%d failed=%d",
+			pattern, stats.Scanned, stats.Rewrapped, stats.Resealed, stats.Failed)
+	}
+}
+
+// rotateUserKey re-wraps a user's DEK (user:<id>:dek) under the current KEK,
+// or re-encrypts a user record (user:<id>) whose email is not under its DEK.
+// The DEK itself is unchanged, so records encrypted with it stay valid.
+func rotateUserKey(ctx context.Context, store KVStore, key string) (rotationOutcome, error) {
+	parts := strings.Split(key, ":")
+	if len(parts) == 2 && !strings.Contains(key, "@") {
+		return rotateUserRecord(ctx, store, key)
+	}
+	if len(parts) != 3 || parts[2] != "dek" {
+		return rotationSkipped, nil // blind index or legacy user:<email> (see migrate up)
+	}
+	userID := strings.TrimSuffix(strings.TrimPrefix(key, "user:"), ":dek")
+	current, _, err := keyProvider.CurrentKEK()
+	if err != nil {
+		return rotationSkipped, err
+	}
+
+	return updateForRotation(ctx, store, key, rotationRewrapped, func(wrapped []byte) ([]byte, error) {
+		if strings.HasPrefix(string(wrapped), current+":") {
+			return nil, nil
+		}
+		dek, err := unwrapDEK(userID, string(wrapped))
+		if err != nil {
+			return nil, err
+		}
+		rewrapped, err := wrapDEK(userID, dek)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(rewrapped), nil
+	})
+}
+
+// rotateUserRecord re-encrypts a user record's email under the owner's DEK
+func rotateUserRecord(ctx context.Context, store KVStore, key string) (rotationOutcome, error) {
+	return updateForRotation(ctx, store, key, rotationResealed, func(raw []byte) ([]byte, error) {
+		var stored storedUser
+		if err := json.Unmarshal(raw, &stored); err != nil {
+			return nil, err
+		}
+		if strings.HasPrefix(stored.EmailEnc, userCiphertextPrefix) {
+			return nil, nil
+		}
+		user, err := unmarshalUser(ctx, raw)
+		if err != nil {
+			return nil, err
+		}
+		return marshalUser(ctx, user)
+	})
+}
+
+// rotateHealthRecord re-encrypts a record (health:<uid>:<id>), or the previous
+// versions in its history list, whose fields are plaintext or under a master
+// key instead of the owner's DEK.
+func rotateHealthRecord(ctx context.Context, store KVStore, key string) (rotationOutcome, error) {
+	parts := strings.Split(key, ":")
+	if len(parts) == 4 && parts[3] == "history" {
+		return rotateHealthHistory(ctx, store, key)
+	}
+	if len(parts) != 3 || parts[2] == "list" {
+		return rotationSkipped, nil // list index or stats cache
+	}
+
+	return updateForRotation(ctx, store, key, rotationResealed, func(raw []byte) ([]byte, error) {
+		var stored storedHealthRecord
+		if err := json.Unmarshal(raw, &stored); err != nil {
+			return nil, err
+		}
+		if !healthRecordNeedsReseal(&stored) {
+			return nil, nil
+		}
+		rec, err := unmarshalHealthRecord(ctx, raw)
+		if err != nil {
+			return nil, err
+		}
+		return marshalHealthRecord(ctx, rec)
+	})
+}
+
+// rotateHealthHistory re-encrypts the versions in a history list
+// (health:<uid>:<id>:history) that need it, keeping their order
+func rotateHealthHistory(ctx context.Context, store KVStore, key string) (rotationOutcome, error) {
+	outcome := rotationSkipped
+	err := store.UpdateList(ctx, key, func(entries []string) ([]string, error) {
+		changed := false
+		for i, raw := range entries {
+			var stored storedHealthRecord
+			if err := json.Unmarshal([]byte(raw), &stored); err != nil {
+				return nil, err
+			}
+			if !healthRecordNeedsReseal(&stored) {
+				continue
+			}
+			rec, err := unmarshalHealthRecord(ctx, []byte(raw))
+			if err != nil {
+				return nil, err
+			}
+			resealed, err := marshalHealthRecord(ctx, rec)
+			if err != nil {
+				return nil, err
+			}
+			entries[i], changed = string(resealed), true
+		}
+		if !changed {
+			return nil, nil
+		}
+		outcome = rotationResealed
+		return entries, nil
+	})
+	if err == errKeyNotFound {
+		return rotationSkipped, nil
+	}
+	if err != nil {
+		return rotationSkipped, err
+	}
+	return outcome, nil
+}
+
+// updateForRotation rewrites key in store through rewrite (nil = already
+// current) and reports changed on success. Keys deleted meanwhile are skipped.
+func updateForRotation(ctx context.Context, store KVStore, key string, changed rotationOutcome,
+	rewrite func([]byte) ([]byte, error)) (rotationOutcome, error) {
+	outcome := rotationSkipped
+	err := store.Update(ctx, key, func(raw []byte) ([]byte, error) {
+		next, err := rewrite(raw)
+		if next != nil && err == nil {
+			outcome = changed
+		}
+		return next, err
+	})
+	if err == errKeyNotFound {
+		return rotationSkipped, nil // shredded or deleted meanwhile
+	}
+	if err != nil {
+		return rotationSkipped, err
+	}
+	return outcome, nil
+}
+
+// healthRecordNeedsReseal reports whether a stored record is not (fully)
+// encrypted with its owner's data key
+func healthRecordNeedsReseal(stored *storedHealthRecord) bool {
+	if !strings.HasPrefix(stored.Notes, userCiphertextPrefix) {
+		return true
+	}
+	if stored.ValueEnc != "" && !strings.HasPrefix(stored.ValueEnc, userCiphertextPrefix) {
+		return true
+	}
+	return securityConfig.EncryptHealthValues && stored.ValueEnc == ""
+}
+
+// ----------------------------------------------------------------------------
+// PostgreSQL rows
+// ----------------------------------------------------------------------------
+
+// rotationRow is one loaded row: its key (for the checkpoint) and how to
+// reseal it (false = already under the owner's DEK)
+type rotationRow struct {
+	key    string
+	reseal func(ctx context.Context, tx *sql.Tx) (bool, error)
+}
+
+// rotationTable loads a batch of rows after a key, locked for the batch's transaction
+type rotationTable struct {
+	name  string
+	start string // key before the first row
+	load  func(ctx context.Context, tx *sql.Tx, after string, limit int64) ([]rotationRow, error)
+}
+
+var postgresRotationTables = []rotationTable{
+	{name: "users", start: uuid.Nil.String(), load: loadUserRotationRows},
+	{name: "health_records", start: uuid.Nil.String(), load: loadHealthRotationRows},
+	{name: "health_record_revisions", start: uuid.Nil.String() + "/0", load: loadRevisionRotationRows},
+}
+
+// rotateTable reseals a table batch by batch (one transaction each),
+// checkpointing the last key in kv after every batch
+func rotateTable(ctx context.Context, db *sql.DB, version string, table rotationTable, batch int64, restart bool, stats *rotationStats) {
+	cursorKey := "keys:rotate:" + version + ":cursor:pg:" + table.name
+	after := table.start
+	if !restart {
+		if saved, err := kv.Get(ctx, cursorKey); err == nil {
+			after = string(saved)
+			log.Printf("[ROTATE] Resuming %s after %s", table.name, after)
+		}
+	}
+
+	for {
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			log.Fatalf("[ROTATE] %s: %v", table.name, err)
+		}
+		rows, err := table.load(ctx, tx, after, batch)
+		if err != nil {
+			tx.Rollback()
+			log.Fatalf("[ROTATE] Loading %s failed: %v", table.name, err)
+		}
+		for _, row := range rows {
+			stats.Scanned++
+			resealed, err := row.reseal(ctx, tx)
+			switch {
+			case err != nil:
+				stats.Failed++
+				log.Printf("[ROTATE] %s %s: %v", table.name, row.key, err)
+			case resealed:
+				stats.Resealed++
+			default:
+				stats.Skipped++
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			log.Fatalf("[ROTATE] Committing %s batch failed: %v (run again to resume)", table.name, err)
+		}
+
+		if int64(len(rows)) < batch {
+			kv.Del(ctx, cursorKey)
+			break
+		}
+		after = rows[len(rows)-1].key
+		kv.Set(ctx, cursorKey, []byte(after), 0)
+		log.Printf("[ROTATE] %s progress: scanned=%d resealed=%d failed=%d",
+			table.name, stats.Scanned, stats.Resealed, stats.Failed)
+	}
+}
+
+func loadUserRotationRows(ctx context.Context, tx *sql.Tx, after string, limit int64) ([]rotationRow, error) {
+	rows, err := tx.QueryContext(ctx,
+		`SELECT id, email_enc FROM users WHERE id > $1 ORDER BY id LIMIT $2 FOR UPDATE`, after, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var batch []rotationRow
+	for rows.Next() {
+		var id, emailEnc string
+		if err := rows.Scan(&id, &emailEnc); err != nil {
+			return nil, err
+		}
+		batch = append(batch, rotationRow{key: id, reseal: func(ctx context.Context, tx *sql.Tx) (bool, error) {
+			if strings.HasPrefix(emailEnc, userCiphertextPrefix) {
+				return false, nil
+			}
+			email, err := decryptForUser(ctx, id, userEmailField, emailEnc)
+			if err != nil {
+				return false, err
+			}
+			if emailEnc, err = encryptForUser(ctx, id, userEmailField, email); err != nil {
+				return false, err
+			}
+			_, err = tx.ExecContext(ctx, `UPDATE users SET email_enc = $2 WHERE id = $1`, id, emailEnc)
+			return err == nil, err
+		}})
+	}
+	return batch, rows.Err()
+}
+
+func loadHealthRotationRows(ctx context.Context, tx *sql.Tx, after string, limit int64) ([]rotationRow, error) {
+	rows, err := tx.QueryContext(ctx,
+		`SELECT id, user_id, value, value_enc, notes FROM health_records
+		  WHERE id > $1 ORDER BY id LIMIT $2 FOR UPDATE`, after, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var batch []rotationRow
+	for rows.Next() {
+		stored, err := scanRotationHealthFields(rows, nil)
+		if err != nil {
+			return nil, err
+		}
+		batch = append(batch, rotationRow{key: stored.ID, reseal: func(ctx context.Context, tx *sql.Tx) (bool, error) {
+			return resealHealthRow(ctx, tx, stored,
+				`UPDATE health_records SET value = $1, value_enc = $2, notes = $3 WHERE id = $4`, stored.ID)
+		}})
+	}
+	return batch, rows.Err()
+}
+
+func loadRevisionRotationRows(ctx context.Context, tx *sql.Tx, after string, limit int64) ([]rotationRow, error) {
+	afterID, afterVersion, _ := strings.Cut(after, "/")
+	rows, err := tx.QueryContext(ctx,
+		`SELECT record_id, user_id, value, value_enc, notes, version FROM health_record_revisions
+		  WHERE (record_id, version) > ($1, $2) ORDER BY record_id, version LIMIT $3 FOR UPDATE`,
+		afterID, afterVersion, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var batch []rotationRow
+	for rows.Next() {
+		var version int
+		stored, err := scanRotationHealthFields(rows, &version)
+		if err != nil {
+			return nil, err
+		}
+		key := stored.ID + "/" + strconv.Itoa(version)
+		batch = append(batch, rotationRow{key: key, reseal: func(ctx context.Context, tx *sql.Tx) (bool, error) {
+			return resealHealthRow(ctx, tx, stored,
+				`UPDATE health_record_revisions SET value = $1, value_enc = $2, notes = $3
+				  WHERE record_id = $4 AND version = $5`, stored.ID, version)
+		}})
+	}
+	return batch, rows.Err()
+}
+
+// scanRotationHealthFields reads id, user_id, value, value_enc, notes (and
+// the version, if asked for)
+func scanRotationHealthFields(rows *sql.Rows, version *int) (*storedHealthRecord, error) {
+	var stored storedHealthRecord
+	var value sql.NullFloat64
+	var valueEnc sql.NullString
+	dest := []interface{}{&stored.ID, &stored.UserID, &value, &valueEnc, &stored.Notes}
+	if version != nil {
+		dest = append(dest, version)
+	}
+	if err := rows.Scan(dest...); err != nil {
+		return nil, err
+	}
+	stored.Value, stored.ValueEnc = value.Float64, valueEnc.String
+	return &stored, nil
+}
+
+// resealHealthRow re-encrypts a record's or revision's notes and value under
+// the owner's DEK; update gets value, value_enc and notes, then the row key
+func resealHealthRow(ctx context.Context, tx *sql.Tx, stored *storedHealthRecord, update string, key ...interface{}) (bool, error) {
+	if !healthRecordNeedsReseal(stored) {
+		return false, nil
+	}
+	rec, err := openHealthRecord(ctx, stored)
+	if err != nil {
+		return false, err
+	}
+	resealed, err := sealHealthRecord(ctx, rec)
+	if err != nil {
+		return false, err
+	}
+	value, valueEnc := storedHealthValue(resealed)
+	_, err = tx.ExecContext(ctx, update, append([]interface{}{value, valueEnc, resealed.Notes}, key...)...)
+	return err == nil, err
+}
@@ -5,10 +5,10 @@ import (
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
-	"github.com/go-chi/httprate"
+	"github.com/go-chi/chi/v5/middleware"
 )
 
-func setupRouter(db *sql.DB) http.Handler {
+func setupRouter(db *sql.DB, cfg *Config) http.Handler {
 	r := chi.NewRouter()
 
 	// security middleware (CIA triad)
@@ -20,46 +20,109 @@ func setupRouter(db *sql.DB) http.Handler {
 	// security & performance middleware
 	r.Use(secureHeaders)
 	r.Use(gzipMiddleware)
-	// rate limit: 60 req per minute per client (tweak sesuai kebutuhan)
-	r.Use(httprate.LimitByIP(60, 1*60))
+
+	// AVAILABILITY: per-IP rate limit, concurrency cap and per-request deadline (see config.go)
+	// (rate-limit tiers are read per request and follow SIGHUP reloads, see reload.go)
+	r.Use(rateLimitByIP(func(s *liveSettings) int { return s.RateLimit.RequestsPerMinute }))
+	r.Use(middleware.Throttle(cfg.Server.MaxConcurrentRequests))
+	r.Use(middleware.Timeout(cfg.Server.RequestTimeout.D()))
+
+	// stricter tier for credential endpoints (one counter shared by all of them)
+	authRateLimit := rateLimitByIP(func(s *liveSettings) int { return s.RateLimit.AuthRequestsPerMinute })
 
 	// public endpoints
 	r.Get("/health", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte("OK"))
 	})
 
+	// protected applies authentication (JWT via bearer header or session cookie,
+	// or HMAC signature), CSRF (cookie sessions only) and idempotency (POST +
+	// Idempotency-Key header). Gateways (signed requests) may only add
+	// records; every other protected route is wrapped in userOnly.
+	protected := func(rg chi.Router) {
+		rg.Use(authMiddleware)
+		rg.Use(CSRFMiddleware)
+		rg.Use(IdempotencyMiddleware)
+	}
+	userOnly := func(r chi.Router, routes func(chi.Router)) {
+		r.Group(func(rg chi.Router) {
+			rg.Use(userOnlyMiddleware)
+			routes(rg)
+		})
+	}
+
+	// HL7 FHIR R4 (protected; the authenticated user is the Patient). Mounted
+	// at /fhir, the base URL FHIR clients expect, and under /api/v1
+	fhirRoutes := func(r chi.Router) {
+		protected(r)
+		r.Post("/Bundle", importFHIRBundleHandler(cfg.Import))
+		userOnly(r, func(r chi.Router) {
+			r.Get("/Observation", searchObservationsHandler)
+			r.Get("/Observation/{id}", readObservationHandler)
+		})
+	}
+	r.Route("/fhir", fhirRoutes)
+
 	// API v1 routes
 	r.Route("/api/v1", func(r chi.Router) {
-		// Public authentication endpoints
+		// Authentication endpoints (one subrouter: chi cannot mount /auth twice)
 		r.Route("/auth", func(r chi.Router) {
-			r.Post("/register", registerHandler)
-			r.Post("/login", loginHandler)
-		})
-
-		// Protected endpoints (require JWT)
-		r.Group(func(rg chi.Router) {
-			rg.Use(jwtMiddleware)
+			// Public (stricter tier against credential stuffing)
+			r.Group(func(rg chi.Router) {
+				rg.Use(authRateLimit)
+				rg.Post("/register", registerHandler)
+				rg.Post("/login", loginHandler)
+				rg.Get("/csrf", csrfTokenHandler)
+			})
 
-			// User auth endpoints
-			rg.Route("/auth", func(r chi.Router) {
-				r.Post("/logout", logoutHandler)
-				r.Get("/me", meHandler)
+			// Protected
+			r.Group(func(rg chi.Router) {
+				protected(rg)
+				userOnly(rg, func(rg chi.Router) {
+					rg.Post("/logout", logoutHandler)
+					rg.Get("/me", meHandler)
+					rg.Delete("/me", eraseAccountHandler)
+				})
 			})
+		})
 
-			// Health data endpoints (CRUD)
-			rg.Route("/health", func(r chi.Router) {
-				r.Post("/", createHealthRecordHandler)
+		// Health data endpoints (CRUD, protected)
+		r.Route("/health", func(r chi.Router) {
+			protected(r)
+			r.Post("/", createHealthRecordHandler)
+			r.Post("/import", importHealthRecordsHandler(cfg.Import))
+			userOnly(r, func(r chi.Router) {
 				r.Get("/", getHealthRecordsHandler)
 				r.Get("/stats", getHealthStatsHandler)
 				r.Delete("/", deleteHealthRecordHandler)
+				r.Get("/{id}", getHealthRecordHandler)
+				r.Delete("/{id}", deleteHealthRecordHandler)
+				r.Put("/{id}", replaceHealthRecordHandler)
+				r.Patch("/{id}", patchHealthRecordHandler)
+				r.Get("/{id}/history", getHealthRecordHistoryHandler)
+				r.Post("/{id}/restore", restoreHealthRecordHandler)
 			})
 		})
+
+		// Personal data export (protected; large exports run as jobs)
+		r.Route("/export", func(r chi.Router) {
+			protected(r)
+			userOnly(r, func(r chi.Router) {
+				r.Get("/", exportHandler(cfg.Export))
+				r.Get("/jobs/{id}", getExportJobHandler)
+				r.Get("/jobs/{id}/download", downloadExportHandler)
+			})
+		})
+
+		r.Route("/fhir", fhirRoutes)
 	})
 
 	// Legacy endpoints (for backward compatibility)
-	r.Post("/login", legacyLoginHandler)
+	r.With(authRateLimit).Post("/login", legacyLoginHandler)
+	// jwtMiddleware also accepts the session cookie, so CSRF applies here too
 	r.Group(func(rg chi.Router) {
 		rg.Use(jwtMiddleware)
+		rg.Use(CSRFMiddleware)
 		rg.Post("/user", createUserHandler)
 	})
 
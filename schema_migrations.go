@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ============================================================================
+// Embedded SQL schema migrations
+// ============================================================================
+//
+// Files: migrations/<version>_<name>.up.sql and .down.sql, both required.
+// Applied versions are recorded in schema_migrations. Every run holds a
+// PostgreSQL advisory lock, so replicas starting together (auto_migrate) or
+// an operator running `migrate up` never apply the same migration twice.
+// Each migration runs in its own transaction together with its bookkeeping row.
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationLockID is the pg_advisory_lock key for schema changes
+const migrationLockID int64 = 0x6865616c7468 // "health"
+
+type schemaMigration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+func (m schemaMigration) String() string {
+	return fmt.Sprintf("%04d_%s", m.Version, m.Name)
+}
+
+// schemaMigrationState is one line of `migrate status`
+type schemaMigrationState struct {
+	Migration schemaMigration
+	AppliedAt *time.Time // nil = pending
+}
+
+// loadSchemaMigrations parses the embedded files, sorted by version
+func loadSchemaMigrations() ([]schemaMigration, error) {
+	entries, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int]*schemaMigration)
+	for _, entry := range entries {
+		file := entry.Name()
+		base, direction, ok := strings.Cut(strings.TrimSuffix(file, ".sql"), ".")
+		versionText, name, ok2 := strings.Cut(base, "_")
+		version, err := strconv.Atoi(versionText)
+		if !ok || !ok2 || err != nil || (direction != "up" && direction != "down") {
+			return nil, fmt.Errorf("migration %s: name must be <version>_<name>.(up|down).sql", file)
+		}
+		body, err := migrationFiles.ReadFile("migrations/" + file)
+		if err != nil {
+			return nil, err
+		}
+		m := byVersion[version]
+		if m == nil {
+			m = &schemaMigration{Version: version, Name: name}
+			byVersion[version] = m
+		} else if m.Name != name {
+			return nil, fmt.Errorf("migration version %d used by %q and %q", version, m.Name, name)
+		}
+		if direction == "up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+
+	migrations := make([]schemaMigration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %s: both .up.sql and .down.sql are required", m)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// migrateSchemaUp applies all pending migrations (or only lists them with dryRun)
+func migrateSchemaUp(ctx context.Context, db *sql.DB, dryRun bool) ([]schemaMigration, error) {
+	migrations, err := loadSchemaMigrations()
+	if err != nil {
+		return nil, err
+	}
+	var done []schemaMigration
+	err = withMigrationLock(ctx, db, func(conn *sql.Conn) error {
+		applied, err := appliedSchemaVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, m := range migrations {
+			if _, ok := applied[m.Version]; ok {
+				continue
+			}
+			if dryRun {
+				log.Printf("[MIGRATE] Dry run: would apply %s", m)
+				done = append(done, m)
+				continue
+			}
+			if err := runSchemaMigration(ctx, conn, m.Up,
+				`INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, m.Version, m.Name); err != nil {
+				return fmt.Errorf("apply %s: %w", m, err)
+			}
+			log.Printf("[MIGRATE] Applied %s", m)
+			done = append(done, m)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// migrateSchemaDown reverts the latest `steps` applied migrations
+func migrateSchemaDown(ctx context.Context, db *sql.DB, steps int, dryRun bool) ([]schemaMigration, error) {
+	migrations, err := loadSchemaMigrations()
+	if err != nil {
+		return nil, err
+	}
+	var done []schemaMigration
+	err = withMigrationLock(ctx, db, func(conn *sql.Conn) error {
+		applied, err := appliedSchemaVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for i := len(migrations) - 1; i >= 0 && len(done) < steps; i-- {
+			m := migrations[i]
+			if _, ok := applied[m.Version]; !ok {
+				continue
+			}
+			if dryRun {
+				log.Printf("[MIGRATE] Dry run: would revert %s", m)
+				done = append(done, m)
+				continue
+			}
+			if err := runSchemaMigration(ctx, conn, m.Down,
+				`DELETE FROM schema_migrations WHERE version = $1`, m.Version); err != nil {
+				return fmt.Errorf("revert %s: %w", m, err)
+			}
+			log.Printf("[MIGRATE] Reverted %s", m)
+			done = append(done, m)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// schemaMigrationStatus lists every known migration; read-only (no lock, no DDL)
+func schemaMigrationStatus(ctx context.Context, db *sql.DB) ([]schemaMigrationState, error) {
+	migrations, err := loadSchemaMigrations()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	var exists bool
+	if err := conn.QueryRowContext(ctx, `SELECT to_regclass('schema_migrations') IS NOT NULL`).Scan(&exists); err != nil {
+		return nil, err
+	}
+	applied := map[int]time.Time{}
+	if exists {
+		if applied, err = appliedSchemaVersions(ctx, conn); err != nil {
+			return nil, err
+		}
+	}
+
+	states := make([]schemaMigrationState, 0, len(migrations))
+	for _, m := range migrations {
+		state := schemaMigrationState{Migration: m}
+		if at, ok := applied[m.Version]; ok {
+			state.AppliedAt = &at
+		}
+		states = append(states, state)
+		delete(applied, m.Version)
+	}
+	for version := range applied {
+		log.Printf("[MIGRATE WARNING] Version %d is applied but unknown to this binary (newer release?)", version)
+	}
+	return states, nil
+}
+
+// withMigrationLock runs fn on one connection holding the advisory lock,
+// after making sure schema_migrations exists
+func withMigrationLock(ctx context.Context, db *sql.DB, fn func(*sql.Conn) error) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, migrationLockID); err != nil {
+		return fmt.Errorf("acquire migration lock: %w", err)
+	}
+	defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, migrationLockID)
+
+	if _, err := conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    BIGINT PRIMARY KEY,
+		name       TEXT NOT NULL,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`); err != nil {
+		return err
+	}
+	return fn(conn)
+}
+
+// appliedSchemaVersions returns version -> applied_at
+func appliedSchemaVersions(ctx context.Context, conn *sql.Conn) (map[int]time.Time, error) {
+	rows, err := conn.QueryContext(ctx, `SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		applied[version] = at
+	}
+	return applied, rows.Err()
+}
+
+// runSchemaMigration executes a migration body and its bookkeeping statement atomically
+func runSchemaMigration(ctx context.Context, conn *sql.Conn, body, bookkeeping string, args ...interface{}) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, body); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, bookkeeping, args...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
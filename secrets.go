@@ -0,0 +1,228 @@
+package main
+
+import (
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ============================================================================
+// CONFIDENTIALITY: Secret Providers (env, *_FILE, Docker secrets) & Hot Reload
+// ============================================================================
+
+// defaultJWTSecret is only accepted outside production
+const defaultJWTSecret = "your-secret-key-change-me-in-production"
+
+// SecretProvider resolves a named secret (e.g. "JWT_SECRET").
+// source describes where it came from, for logging (never the value).
+type SecretProvider interface {
+	Lookup(name string) (value, source string, ok bool)
+}
+
+// envSecretProvider reads NAME from the environment
+type envSecretProvider struct{}
+
+func (envSecretProvider) Lookup(name string) (string, string, bool) {
+	if v := os.Getenv(name); v != "" {
+		return v, "env", true
+	}
+	return "", "", false
+}
+
+// fileSecretProvider reads the file named by NAME_FILE (Kubernetes/Vault agent style)
+type fileSecretProvider struct{}
+
+func (fileSecretProvider) Lookup(name string) (string, string, bool) {
+	path := os.Getenv(name + "_FILE")
+	if path == "" {
+		return "", "", false
+	}
+	return readSecretFile(path)
+}
+
+// dockerSecretProvider reads <dir>/<name> (lowercase first), e.g. /run/secrets/jwt_secret
+type dockerSecretProvider struct {
+	dir string
+}
+
+func (p dockerSecretProvider) Lookup(name string) (string, string, bool) {
+	for _, file := range []string{strings.ToLower(name), name} {
+		if v, source, ok := readSecretFile(filepath.Join(p.dir, file)); ok {
+			return v, source, true
+		}
+	}
+	return "", "", false
+}
+
+// chainSecretProvider returns the first provider that has the secret
+type chainSecretProvider []SecretProvider
+
+func (c chainSecretProvider) Lookup(name string) (string, string, bool) {
+	for _, p := range c {
+		if v, source, ok := p.Lookup(name); ok {
+			return v, source, true
+		}
+	}
+	return "", "", false
+}
+
+// readSecretFile reads a secret file, dropping the trailing newline editors add
+func readSecretFile(path string) (string, string, bool) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("[SECURITY] Cannot read secret file %s: %v", path, err)
+		}
+		return "", "", false
+	}
+	v := strings.TrimRight(string(raw), "\r\n")
+	if v == "" {
+		return "", "", false
+	}
+	return v, "file:" + path, true
+}
+
+// secretProvider is the lookup order: env, NAME_FILE, then the secrets directory
+var secretProvider SecretProvider = chainSecretProvider{
+	envSecretProvider{},
+	fileSecretProvider{},
+	dockerSecretProvider{dir: getEnvOrDefault("SECRETS_DIR", "/run/secrets")},
+}
+
+// isProduction reports whether strict secret handling applies
+func isProduction() bool {
+	if appConfig != nil {
+		return appConfig.Environment == "production"
+	}
+	return os.Getenv("ENVIRONMENT") == "production"
+}
+
+// lookupSecret resolves a secret; devDefault is used only outside production
+func lookupSecret(name, devDefault string) (value, source string) {
+	if v, source, ok := secretProvider.Lookup(name); ok {
+		return v, source
+	}
+	if devDefault != "" && isProduction() {
+		log.Fatalf("[SECURITY] %s not set; refusing built-in default in production", name)
+	}
+	return devDefault, ""
+}
+
+// loadSecret is lookupSecret plus a startup log of the source (never the value)
+func loadSecret(name, devDefault string) string {
+	v, source := lookupSecret(name, devDefault)
+	if source != "" {
+		infof("[SECURITY] %s loaded from %s", name, source)
+	}
+	return v
+}
+
+// ----------------------------------------------------------------------------
+// Hot-reloadable secrets (JWT, request signing keys)
+// ----------------------------------------------------------------------------
+
+// jwtSecrets keeps the previous secret after a rotation so tokens issued
+// just before it stay valid until they expire.
+type jwtSecrets struct {
+	current   []byte
+	previous  []byte
+	rotatedAt time.Time
+}
+
+// jwtPreviousSecretTTL matches the JWT lifetime (see generateJWT)
+const jwtPreviousSecretTTL = time.Hour
+
+var (
+	currentJWTSecrets  atomic.Pointer[jwtSecrets]
+	currentSigningKeys atomic.Pointer[map[string]string]
+)
+
+// rotatingSecretNames are the secrets watchSecrets re-resolves
+var rotatingSecretNames = []string{"JWT_SECRET", "REQUEST_SIGNING_KEYS", "REQUEST_SIGNING_SECRET"}
+
+// resolvedSecrets holds the last value each rotating secret resolved to ("" =
+// not set). Only the startup load and the watchSecrets goroutine touch it.
+var resolvedSecrets = map[string]string{}
+
+// loadRotatingSecrets resolves JWT and signing secrets at startup. Only here
+// does a missing JWT_SECRET fall back to the dev default (or stop the server
+// in production).
+func loadRotatingSecrets() {
+	for _, name := range rotatingSecretNames {
+		v, source, _ := secretProvider.Lookup(name)
+		if source != "" {
+			infof("[SECURITY] %s loaded from %s", name, source)
+		}
+		resolvedSecrets[name] = v
+	}
+	jwt := resolvedSecrets["JWT_SECRET"]
+	if jwt == "" {
+		jwt, _ = lookupSecret("JWT_SECRET", defaultJWTSecret)
+	}
+	currentJWTSecrets.Store(&jwtSecrets{current: []byte(jwt)})
+	keys := signingKeysFrom(resolvedSecrets)
+	currentSigningKeys.Store(&keys)
+}
+
+// reloadRotatingSecrets re-resolves the secrets and publishes changes. A
+// secret that was set but can no longer be resolved (file being replaced,
+// unreadable mount) keeps its last good value and logs a warning.
+func reloadRotatingSecrets() {
+	for _, name := range rotatingSecretNames {
+		if v, _, ok := secretProvider.Lookup(name); ok {
+			resolvedSecrets[name] = v
+		} else if resolvedSecrets[name] != "" {
+			log.Printf("[SECURITY WARNING] %s could not be resolved; keeping the last good value", name)
+		}
+	}
+
+	if jwt := resolvedSecrets["JWT_SECRET"]; jwt != "" {
+		if old := currentJWTSecrets.Load(); string(old.current) != jwt {
+			currentJWTSecrets.Store(&jwtSecrets{current: []byte(jwt), previous: old.current, rotatedAt: time.Now()})
+			log.Println("[SECURITY] JWT_SECRET rotated (previous secret accepted for 1h)")
+		}
+	}
+
+	keys := signingKeysFrom(resolvedSecrets)
+	if old := currentSigningKeys.Load(); !maps.Equal(*old, keys) {
+		currentSigningKeys.Store(&keys)
+		log.Printf("[SECURITY] Request signing keys reloaded (%d key(s))", len(keys))
+	}
+}
+
+// signingKeysFrom builds the key ID -> secret map (REQUEST_SIGNING_SECRET is "default")
+func signingKeysFrom(secrets map[string]string) map[string]string {
+	keys := parseSigningKeys(secrets["REQUEST_SIGNING_KEYS"])
+	if secret := secrets["REQUEST_SIGNING_SECRET"]; secret != "" {
+		keys["default"] = secret
+	}
+	return keys
+}
+
+// watchSecrets re-resolves rotating secrets every interval, so file-based
+// secrets (Kubernetes, Docker, Vault agent) are picked up without a restart.
+func watchSecrets(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		reloadRotatingSecrets()
+	}
+}
+
+// GetRequestSigningKeys returns the current key ID -> secret map (read-only)
+func GetRequestSigningKeys() map[string]string {
+	return *currentSigningKeys.Load()
+}
+
+// getPreviousJWTSecret returns the pre-rotation secret while it is still accepted
+func getPreviousJWTSecret() []byte {
+	s := currentJWTSecrets.Load()
+	if s == nil || s.previous == nil || time.Since(s.rotatedAt) > jwtPreviousSecretTTL {
+		return nil
+	}
+	return s.previous
+}
@@ -2,7 +2,10 @@ package main
 
 // Global constant for default redirect host
 import (
+	"context"
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
 	"log"
@@ -21,54 +24,78 @@ const DefaultRedirectHost = "localhost:8443"
 // SecurityConfig holds CIA-compliant security settings
 type SecurityConfig struct {
 	// Confidentiality: encryption and secret management
-	JWTSecret      string
-	EncryptionKey  string // future use for data encryption
-	AllowedOrigins []string
-	RequireHTTPS   bool
+	EncryptionKey        string // master AES-256 key (base64/hex) when no keyring file is used
+	EncryptionKeyVersion string // version label of EncryptionKey
+	EncryptionOldKeys    string // "version=key,..." previous keys, accepted on read only
+	KeyringFile          string // JSON keyring with versioned master keys, see keyring.go
+	BlindIndexKey        []byte // HMAC key for email lookup index, see user_records.go
+	EncryptHealthValues  bool   // also encrypt HealthRecord.Value (notes always encrypted)
+	RequireHTTPS         bool   // CORS origins and redirect hosts are reloadable, see reload.go
 
 	// Integrity: validation and signing
 	CSRFTokenLength      int
 	CSRFTokenExpiry      time.Duration
+	CSRFStore            string // "memory" (single replica) or "redis" (shared)
 	MaxRequestBodySize   int64
-	RequestSigningSecret string
-
-	// Availability: performance and resilience
-	RateLimitPerMinute    int
-	RequestTimeout        time.Duration
-	MaxConcurrentRequests int
+	SignatureMaxSkew     time.Duration       // signing keys: see GetRequestSigningKeys (hot-reloaded)
+	SigningPatients      map[string][]string // key ID -> user IDs the gateway may act for
+	SecretReloadInterval time.Duration       // how often rotating secrets are re-read
 }
 
 var securityConfig *SecurityConfig
 
-// InitSecurityConfig initializes security from environment variables
-func InitSecurityConfig() {
+// InitSecurityConfig initializes security from the loaded configuration
+// (see config.go) plus secrets (see secrets.go)
+func InitSecurityConfig(cfg *Config) {
+	appConfig = cfg
+	sec := cfg.Security
 	securityConfig = &SecurityConfig{
-		// CONFIDENTIALITY: Load secrets from environment (never hardcode in production)
-		JWTSecret:      getEnvOrDefault("JWT_SECRET", "your-secret-key-change-me-in-production"),
-		EncryptionKey:  getEnvOrDefault("ENCRYPTION_KEY", ""),
-		AllowedOrigins: []string{getEnvOrDefault("ALLOWED_ORIGINS", "https://localhost:8443")},
-		RequireHTTPS:   getEnvOrDefault("REQUIRE_HTTPS", "true") == "true",
+		// CONFIDENTIALITY: Secrets come from env, *_FILE or /run/secrets (see secrets.go)
+		EncryptionKey:        loadSecret("ENCRYPTION_KEY", ""),
+		EncryptionKeyVersion: sec.EncryptionKeyVersion,
+		EncryptionOldKeys:    loadSecret("ENCRYPTION_OLD_KEYS", ""),
+		KeyringFile:          sec.KeyringFile,
+		BlindIndexKey:        []byte(loadSecret("BLIND_INDEX_KEY", "")),
+		EncryptHealthValues:  sec.EncryptHealthValues,
+		RequireHTTPS:         sec.RequireHTTPS,
 
 		// INTEGRITY: Input validation and request signing
 		CSRFTokenLength:      32,
-		CSRFTokenExpiry:      15 * time.Minute,
-		MaxRequestBodySize:   10 * 1024 * 1024, // 10MB
-		RequestSigningSecret: getEnvOrDefault("REQUEST_SIGNING_SECRET", ""),
-
-		// AVAILABILITY: Rate limiting and timeouts
-		RateLimitPerMinute:    100,
-		RequestTimeout:        30 * time.Second,
-		MaxConcurrentRequests: 1000,
+		CSRFTokenExpiry:      sec.CSRFTokenExpiry.D(),
+		CSRFStore:            sec.CSRFStore,
+		MaxRequestBodySize:   sec.MaxRequestBodySize,
+		SignatureMaxSkew:     sec.SignatureMaxSkew.D(),
+		SigningPatients:      parseSigningPatients(sec.SigningPatients),
+		SecretReloadInterval: sec.SecretReloadInterval.D(),
 	}
 
-	// Warn if using default secrets in production
-	if os.Getenv("ENVIRONMENT") == "production" {
-		if securityConfig.JWTSecret == "your-secret-key-change-me-in-production" {
-			log.Println("[SECURITY WARNING] Using default JWT secret in production! Set JWT_SECRET environment variable.")
+	// CORS origins, redirect hosts, rate limits and log level (SIGHUP-reloadable)
+	applyLiveSettings(cfg)
+
+	// JWT + request signing secrets are hot-reloaded (see watchSecrets)
+	loadRotatingSecrets()
+
+	// Refuse insecure fallbacks in production (JWT default is refused in lookupSecret)
+	if isProduction() {
+		if len(securityConfig.BlindIndexKey) == 0 {
+			log.Fatal("[SECURITY] BLIND_INDEX_KEY must be set in production")
 		}
+		if securityConfig.EncryptionKey == "" && securityConfig.KeyringFile == "" {
+			log.Fatal("[SECURITY] ENCRYPTION_KEY or KEYRING_FILE must be set in production")
+		}
+	}
+
+	// Blind index key must stay stable: changing it makes every user unfindable
+	if len(securityConfig.BlindIndexKey) == 0 {
+		log.Println("[SECURITY WARNING] BLIND_INDEX_KEY not set; deriving it from JWT_SECRET (rotating JWT_SECRET will break logins)")
+		mac := hmac.New(sha256.New, GetJWTSecret())
+		mac.Write([]byte("blind-index/email"))
+		securityConfig.BlindIndexKey = mac.Sum(nil)
 	}
 
-	log.Println("[SECURITY] CIA framework initialized")
+	initEncryption()
+
+	infof("[SECURITY] CIA framework initialized")
 	logSecurityStatus()
 }
 
@@ -84,26 +111,17 @@ func getEnvOrDefault(key, defaultValue string) string {
 // CONFIDENTIALITY: Secret Management & Encryption
 // ============================================================================
 
-// GetJWTSecret returns the configured JWT secret (loaded from environment)
+// GetJWTSecret returns the current JWT secret (hot-reloaded, see secrets.go)
 func GetJWTSecret() []byte {
-	if securityConfig == nil {
+	secrets := currentJWTSecrets.Load()
+	if secrets == nil {
 		log.Fatal("[SECURITY] Security config not initialized. Call InitSecurityConfig first.")
 	}
-	return []byte(securityConfig.JWTSecret)
+	return secrets.current
 }
 
-// EncryptSensitiveData encrypts sensitive strings (future implementation)
-func EncryptSensitiveData(plaintext string) (string, error) {
-	// Placeholder for AES-256-GCM encryption
-	// In production, use proper encryption with random nonce
-	return plaintext, nil
-}
-
-// DecryptSensitiveData decrypts encrypted data (future implementation)
-func DecryptSensitiveData(ciphertext string) (string, error) {
-	// Placeholder for AES-256-GCM decryption
-	return ciphertext, nil
-}
+// EncryptSensitiveData / DecryptSensitiveData: see encryption.go (AES-256-GCM)
+// Per-user envelope encryption: see envelope.go
 
 // ============================================================================
 // INTEGRITY: CSRF Protection & Request Validation
@@ -115,31 +133,28 @@ type CSRFToken struct {
 	ExpiresAt time.Time `json:"expires_at"`
 }
 
-// csrfTokenStore is a simple in-memory store (replace with Redis in production)
-var csrfTokenStore = make(map[string]time.Time)
-
-// GenerateCSRFToken creates a new CSRF token
-func GenerateCSRFToken() (string, error) {
+// GenerateCSRFToken creates a new CSRF token and registers it in csrfStore
+func GenerateCSRFToken(ctx context.Context) (string, error) {
 	bytes := make([]byte, 32)
 	if _, err := rand.Read(bytes); err != nil {
 		return "", err
 	}
 	token := base64.StdEncoding.EncodeToString(bytes)
 	expiresAt := time.Now().Add(securityConfig.CSRFTokenExpiry)
-	csrfTokenStore[token] = expiresAt
+	if err := csrfStore.Save(ctx, token, expiresAt); err != nil {
+		return "", err
+	}
 	return token, nil
 }
 
-// ValidateCSRFToken verifies CSRF token validity and expiry
-func ValidateCSRFToken(token string) bool {
-	if expiry, exists := csrfTokenStore[token]; exists {
-		if time.Now().Before(expiry) {
-			delete(csrfTokenStore, token) // One-time use
-			return true
-		}
-		delete(csrfTokenStore, token) // Expired, clean up
+// ValidateCSRFToken verifies CSRF token validity and expiry (one-time use)
+func ValidateCSRFToken(ctx context.Context, token string) bool {
+	ok, err := csrfStore.Consume(ctx, token)
+	if err != nil {
+		log.Printf("[SECURITY] CSRF store error: %v", err)
+		return false
 	}
-	return false
+	return ok
 }
 
 // ValidateRequestSize enforces max body size (INTEGRITY: prevent payload attacks)
@@ -179,7 +194,7 @@ func RequestLoggingMiddleware(next http.Handler) http.Handler {
 		start := time.Now()
 		log.Printf("[AUDIT] %s %s from %s at %s", r.Method, r.URL.Path, r.RemoteAddr, start.Format(time.RFC3339))
 		next.ServeHTTP(w, r)
-		log.Printf("[AUDIT] Completed in %v", time.Since(start))
+		debugf("Completed %s %s in %v", r.Method, r.URL.Path, time.Since(start))
 	})
 }
 
@@ -204,15 +219,8 @@ func isValidRedirectURL(redirectURL string) bool {
 		return false
 	}
 
-	// Validate host is in allowed list (whitelist validation)
-	// For local dev, allow localhost; for prod, use environment config
-	allowedHosts := map[string]bool{
-		DefaultRedirectHost: true,
-		"127.0.0.1:8443":    true,
-		"[::1]:8443":        true,
-	}
-
-	if !allowedHosts[u.Host] {
+	// Validate host is in allowed list (whitelist validation, see security.redirect_allowed_hosts)
+	if !liveConfig().RedirectAllowedHosts[u.Host] {
 		log.Printf("[SECURITY] Attempted open redirect to: %s", u.Host)
 		return false
 	}
@@ -225,20 +233,12 @@ func HTTPSRedirectMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if securityConfig.RequireHTTPS && r.Header.Get("X-Forwarded-Proto") != "https" && r.URL.Scheme != "https" {
 			// Only allow redirect for strict internal hosts (never user-controlled)
-			allowedHosts := map[string]bool{
-				"localhost":         true,
-				DefaultRedirectHost: true,
-				"127.0.0.1":         true,
-				"127.0.0.1:8443":    true,
-				"[::1]":             true,
-				"[::1]:8443":        true,
-			}
-			if allowedHosts[r.Host] {
-				// Use REDIRECT_HOST from environment, fallback to localhost:8443
-				safeHost := getEnvOrDefault("REDIRECT_HOST", DefaultRedirectHost)
+			settings := liveConfig()
+			if settings.RedirectAllowedHosts[r.Host] {
+				// Redirect target is fixed by config (security.redirect_host), never taken from the request
 				u := &url.URL{
 					Scheme:   "https",
-					Host:     safeHost,
+					Host:     settings.RedirectHost,
 					Path:     r.URL.Path,
 					RawQuery: r.URL.RawQuery,
 				}
@@ -261,17 +261,22 @@ func HTTPSRedirectMiddleware(next http.Handler) http.Handler {
 func CORSMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		origin := r.Header.Get("Origin")
-		allowed := false
-		for _, o := range securityConfig.AllowedOrigins {
+		allowed, credentials := false, false
+		for _, o := range liveConfig().AllowedOrigins {
 			if o == "*" || origin == o {
 				allowed = true
+				credentials = o != "*" // never send cookies to a wildcard origin
 				break
 			}
 		}
 		if allowed {
 			w.Header().Set("Access-Control-Allow-Origin", origin)
-			w.Header().Set("Access-Control-Allow-Methods", "GET,POST,PUT,DELETE,OPTIONS")
-			w.Header().Set("Access-Control-Allow-Headers", "Content-Type,Authorization,X-CSRF-Token")
+			if credentials {
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			}
+			w.Header().Set("Access-Control-Allow-Methods", "GET,POST,PUT,PATCH,DELETE,OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type,Authorization,X-CSRF-Token,Idempotency-Key,If-Match,If-None-Match")
+			w.Header().Set("Access-Control-Expose-Headers", "ETag")
 			w.Header().Set("Access-Control-Max-Age", "3600")
 		}
 		if r.Method == http.MethodOptions {
@@ -292,15 +297,22 @@ func logSecurityStatus() {
 	log.Println("============================================================")
 	log.Println("[CONFIDENTIALITY]")
 	log.Printf("  ✓ TLS: Enabled (1.2+)")
-	log.Printf("  ✓ JWT Secret: Loaded from environment")
+	log.Printf("  ✓ JWT Secret: Loaded (env / *_FILE / secrets dir, reload every %v)", securityConfig.SecretReloadInterval)
 	log.Printf("  ✓ HTTPS Redirect: %v", securityConfig.RequireHTTPS)
+	if keyProvider != nil {
+		version, _, _ := keyProvider.CurrentKEK()
+		log.Printf("  ✓ Encryption at Rest: Enabled (per-user keys, master key %s, values: %v)", version, securityConfig.EncryptHealthValues)
+	} else {
+		log.Printf("  ✗ Encryption at Rest: Disabled (set ENCRYPTION_KEY or KEYRING_FILE)")
+	}
 	log.Println("[INTEGRITY]")
 	log.Printf("  ✓ Input Validation: Enabled (max body: %d bytes)", securityConfig.MaxRequestBodySize)
 	log.Printf("  ✓ CSRF Protection: Enabled (%d min expiry)", int(securityConfig.CSRFTokenExpiry.Minutes()))
+	log.Printf("  ✓ Request Signing: %d client key(s), %v skew", len(GetRequestSigningKeys()), securityConfig.SignatureMaxSkew)
 	log.Printf("  ✓ Request Logging: Enabled (audit trail)")
 	log.Println("[AVAILABILITY]")
-	log.Printf("  ✓ Rate Limiting: %d req/min", securityConfig.RateLimitPerMinute)
-	log.Printf("  ✓ Request Timeout: %v", securityConfig.RequestTimeout)
+	log.Printf("  ✓ Rate Limiting: %d req/min (auth: %d req/min) per IP", appConfig.RateLimit.RequestsPerMinute, appConfig.RateLimit.AuthRequestsPerMinute)
+	log.Printf("  ✓ Request Timeout: %v (max %d concurrent)", appConfig.Server.RequestTimeout.D(), appConfig.Server.MaxConcurrentRequests)
 	log.Printf("  ✓ Panic Recovery: Enabled")
 	log.Println("============================================================")
 }
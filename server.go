@@ -2,15 +2,14 @@ package main
 
 import (
 	"net/http"
-	"time"
 )
 
-func newSecureServer(addr string, handler http.Handler) *http.Server {
+func newSecureServer(cfg ServerConfig, handler http.Handler) *http.Server {
 	return &http.Server{
-		Addr:         addr,
+		Addr:         cfg.Addr,
 		Handler:      handler,
-		ReadTimeout:  5 * time.Second,
-		WriteTimeout: 10 * time.Second,
-		IdleTimeout:  60 * time.Second,
+		ReadTimeout:  cfg.ReadTimeout.D(),
+		WriteTimeout: cfg.WriteTimeout.D(),
+		IdleTimeout:  cfg.IdleTimeout.D(),
 	}
 }
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+// ============================================================================
+// Browser Sessions (Cookie Auth) & CSRF Protection
+// ============================================================================
+
+const (
+	// __Host- prefix: browser only accepts the cookie if Secure, Path=/ and no Domain
+	sessionCookieName = "__Host-session"
+	csrfCookieName    = "__Host-csrf"
+	csrfHeaderName    = "X-CSRF-Token"
+
+	// sessionModeCookie is requested by browser clients on login/register
+	sessionModeCookie = "cookie"
+
+	// auth methods stored in request context under "auth_method"
+	authMethodBearer = "bearer"
+	authMethodCookie = "cookie"
+
+	sessionMaxAge = 3600 // seconds, matches JWT expiry
+)
+
+// setSessionCookie stores the JWT in an HttpOnly cookie (CONFIDENTIALITY: no JS access)
+func setSessionCookie(w http.ResponseWriter, token string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    token,
+		Path:     "/",
+		MaxAge:   sessionMaxAge,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// clearSessionCookie expires the session and CSRF cookies
+func clearSessionCookie(w http.ResponseWriter) {
+	for _, name := range []string{sessionCookieName, csrfCookieName} {
+		http.SetCookie(w, &http.Cookie{
+			Name:     name,
+			Value:    "",
+			Path:     "/",
+			MaxAge:   -1,
+			HttpOnly: true,
+			Secure:   true,
+			SameSite: http.SameSiteStrictMode,
+		})
+	}
+}
+
+// csrfTokenHandler issues a one-time CSRF token (double-submit: body + cookie)
+// GET /api/v1/auth/csrf
+func csrfTokenHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	token, err := GenerateCSRFToken(r.Context())
+	if err != nil {
+		log.Printf("[SECURITY] CSRF token generation failed: %v", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to generate CSRF token"})
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookieName,
+		Value:    token,
+		Path:     "/",
+		MaxAge:   int(securityConfig.CSRFTokenExpiry.Seconds()),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(CSRFToken{
+		Token:     token,
+		ExpiresAt: time.Now().Add(securityConfig.CSRFTokenExpiry),
+	})
+}
+
+// CSRFMiddleware requires a valid CSRF token on unsafe methods for cookie sessions (INTEGRITY)
+// Bearer-token requests are not sent automatically by browsers, so they are exempt.
+func CSRFMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method, _ := r.Context().Value("auth_method").(string)
+		if isSafeMethod(r.Method) || method != authMethodCookie {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Double-submit: header must match cookie, and token must be one we issued
+		headerToken := r.Header.Get(csrfHeaderName)
+		cookie, err := r.Cookie(csrfCookieName)
+		if headerToken == "" || err != nil ||
+			subtle.ConstantTimeCompare([]byte(headerToken), []byte(cookie.Value)) != 1 ||
+			!ValidateCSRFToken(r.Context(), headerToken) {
+			log.Printf("[SECURITY] CSRF validation failed: %s %s", r.Method, r.URL.Path)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Invalid or missing CSRF token"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isSafeMethod reports whether the HTTP method is read-only (RFC 9110)
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
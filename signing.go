@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ============================================================================
+// INTEGRITY: HMAC-SHA256 Request Signing (API clients / device gateways)
+// ============================================================================
+
+const (
+	signatureHeader          = "X-Signature"
+	signatureKeyIDHeader     = "X-Signature-Key-Id"
+	signatureTimestampHeader = "X-Signature-Timestamp" // unix seconds
+	signatureNonceHeader     = "X-Signature-Nonce"
+	onBehalfOfHeader         = "X-On-Behalf-Of" // patient (user ID) the gateway writes for
+
+	authMethodSignature = "signature"
+
+	minNonceLength = 16
+	maxNonceLength = 128
+)
+
+// canonicalRequest builds the string clients sign:
+//
+//	METHOD\nPATH\nSORTED_QUERY\nHEX(SHA256(BODY))\nTIMESTAMP\nNONCE\nON_BEHALF_OF
+func canonicalRequest(r *http.Request, body []byte, timestamp, nonce string) string {
+	bodyHash := sha256.Sum256(body)
+	return strings.Join([]string{
+		r.Method,
+		r.URL.EscapedPath(),
+		r.URL.Query().Encode(), // Encode sorts by key
+		hex.EncodeToString(bodyHash[:]),
+		timestamp,
+		nonce,
+		r.Header.Get(onBehalfOfHeader),
+	}, "\n")
+}
+
+// signRequest returns the hex HMAC-SHA256 of the canonical request
+func signRequest(secret []byte, canonical string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(canonical))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// signedRequestMiddleware authenticates requests signed with a per-client key.
+// The client (key ID, "client" in context) acts for the patient named in
+// X-On-Behalf-Of ("user" in context), who must be on the key's allow-list
+// (security.signing_patients).
+func signedRequestMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keyID := r.Header.Get(signatureKeyIDHeader)
+		timestamp := r.Header.Get(signatureTimestampHeader)
+		nonce := r.Header.Get(signatureNonceHeader)
+		signature := r.Header.Get(signatureHeader)
+
+		reject := func(reason string) {
+			log.Printf("[SECURITY] Rejected signed request (%s): key=%q %s %s", reason, keyID, r.Method, r.URL.Path)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		}
+
+		secret, ok := GetRequestSigningKeys()[keyID]
+		if !ok || secret == "" {
+			reject("unknown key id")
+			return
+		}
+		if len(nonce) < minNonceLength || len(nonce) > maxNonceLength {
+			reject("invalid nonce")
+			return
+		}
+
+		// Reject stale or future timestamps (INTEGRITY: bounded replay window)
+		ts, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil {
+			reject("invalid timestamp")
+			return
+		}
+		skew := time.Since(time.Unix(ts, 0))
+		if skew < -securityConfig.SignatureMaxSkew || skew > securityConfig.SignatureMaxSkew {
+			reject("timestamp outside skew window")
+			return
+		}
+
+		// Read body for hashing, then restore it for the handler
+		body, err := io.ReadAll(io.LimitReader(r.Body, securityConfig.MaxRequestBodySize+1))
+		if err != nil || int64(len(body)) > securityConfig.MaxRequestBodySize {
+			http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body.Close()
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		expected := signRequest([]byte(secret), canonicalRequest(r, body, timestamp, nonce))
+		if !hmac.Equal([]byte(expected), []byte(strings.ToLower(signature))) {
+			reject("signature mismatch")
+			return
+		}
+
+		// Nonce is checked last so unauthenticated callers cannot burn nonces
+		fresh, err := rememberNonce(r.Context(), keyID, nonce)
+		if err != nil {
+			log.Printf("[SECURITY] Nonce store error: %v", err)
+			http.Error(w, "Service unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		if !fresh {
+			reject("replayed nonce")
+			return
+		}
+
+		patient := r.Header.Get(onBehalfOfHeader)
+		if patient == "" || !slices.Contains(securityConfig.SigningPatients[keyID], patient) {
+			log.Printf("[SECURITY] Rejected signed request (patient not allowed): key=%q patient=%q %s %s", keyID, patient, r.Method, r.URL.Path)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), "user", patient)
+		ctx = context.WithValue(ctx, "client", keyID)
+		ctx = context.WithValue(ctx, "auth_method", authMethodSignature)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// rememberNonce records a nonce in the KV store; returns false if it was already seen.
+// Nonces are kept for twice the skew window, after which the timestamp check rejects them anyway.
+func rememberNonce(ctx context.Context, keyID, nonce string) (bool, error) {
+	key := "sig:nonce:" + keyID + ":" + nonce
+	return kv.SetNX(ctx, key, []byte("1"), 2*securityConfig.SignatureMaxSkew)
+}
+
+// parseSigningPatients splits the space-separated user IDs of each key
+func parseSigningPatients(spec map[string]string) map[string][]string {
+	patients := make(map[string][]string, len(spec))
+	for keyID, ids := range spec {
+		patients[keyID] = strings.Fields(ids)
+	}
+	return patients
+}
+
+// requestPrincipal is who made the request: the user, or "client:<key ID>"
+// for a gateway acting for the user (recorded as a revision's changed_by)
+func requestPrincipal(r *http.Request) string {
+	if client, ok := r.Context().Value("client").(string); ok {
+		return "client:" + client
+	}
+	userID, _ := r.Context().Value("user").(string)
+	return userID
+}
+
+// parseSigningKeys parses "keyID=secret,keyID2=secret2"
+func parseSigningKeys(spec string) map[string]string {
+	keys := make(map[string]string)
+	for _, pair := range strings.Split(spec, ",") {
+		id, secret, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || id == "" || secret == "" {
+			continue
+		}
+		keys[id] = secret
+	}
+	return keys
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ============================================================================
+// User administration commands (user create-admin, user deactivate,
+// user legal-hold, user set-password)
+// ============================================================================
+
+// runUserCreateAdmin implements
+// `server user create-admin --email E --name N [--password-file F]`.
+// The password is read from --password-file, ADMIN_PASSWORD or stdin (first
+// line), never from a flag, so it does not end up in shell history.
+func runUserCreateAdmin(args []string) {
+	fs := flag.NewFlagSet("user create-admin", flag.ExitOnError)
+	email := fs.String("email", "", "admin email (required)")
+	name := fs.String("name", "", "full name (required)")
+	passwordFile := fs.String("password-file", "", "file containing the password")
+	cfg := loadCommandConfig(fs, args)
+
+	password, err := readAdminPassword(*passwordFile)
+	if err != nil {
+		log.Fatalf("[USER] %v", err)
+	}
+	req := RegisterRequest{Email: *email, Password: password, FullName: *name}
+	if err := validate.Struct(req); err != nil {
+		log.Fatalf("[USER] Invalid input: %v", err)
+	}
+
+	ctx, _ := initCommand(cfg)
+	hashedPassword, err := HashPassword(password)
+	if err != nil {
+		log.Fatalf("[USER] Password hashing failed: %v", err)
+	}
+	now := time.Now()
+	user := &User{
+		ID:        uuid.New().String(),
+		Email:     req.Email,
+		Password:  hashedPassword,
+		FullName:  req.FullName,
+		Role:      roleAdmin,
+		Active:    true,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := userStore.Create(ctx, user); err != nil {
+		log.Fatalf("[USER] Failed to create admin: %v", err)
+	}
+	auditEvent(ctx, user.ID, auditUserRegistered, "Admin user created via CLI: %s", user.ID)
+	fmt.Println(user.ID)
+}
+
+// readAdminPassword resolves the password without taking it as a flag value
+func readAdminPassword(path string) (string, error) {
+	if path != "" {
+		v, _, ok := readSecretFile(path)
+		if !ok {
+			return "", fmt.Errorf("cannot read password file %s", path)
+		}
+		return v, nil
+	}
+	if v := os.Getenv("ADMIN_PASSWORD"); v != "" {
+		return v, nil
+	}
+	fmt.Fprint(os.Stderr, "Password: ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", fmt.Errorf("no password given (use --password-file, ADMIN_PASSWORD or stdin)")
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// runUserDeactivate implements `server user deactivate (--email E | --id ID)`.
+// Deactivated users cannot log in; already issued tokens expire within 1h.
+func runUserDeactivate(args []string) {
+	fs := flag.NewFlagSet("user deactivate", flag.ExitOnError)
+	email := fs.String("email", "", "user email")
+	id := fs.String("id", "", "user ID")
+	cfg := loadCommandConfig(fs, args)
+
+	ctx, _ := initCommand(cfg)
+	user, err := lookupUserForCommand(ctx, *id, *email)
+	if err != nil {
+		log.Fatalf("[USER] %v", err)
+	}
+	if !user.Active {
+		log.Printf("[USER] User %s is already inactive", user.ID)
+		return
+	}
+	if err := userStore.Deactivate(ctx, user.ID); err != nil {
+		log.Fatalf("[USER] Failed to deactivate %s: %v", user.ID, err)
+	}
+	auditEvent(ctx, user.ID, auditUserDeactivated, "User deactivated via CLI: %s", user.ID)
+}
+
+// runUserLegalHold implements `server user legal-hold (--email E | --id ID) [--release]`.
+// Held users are skipped by retention purges and cannot erase their account.
+func runUserLegalHold(args []string) {
+	fs := flag.NewFlagSet("user legal-hold", flag.ExitOnError)
+	email := fs.String("email", "", "user email")
+	id := fs.String("id", "", "user ID")
+	release := fs.Bool("release", false, "release the hold instead of placing it")
+	cfg := loadCommandConfig(fs, args)
+
+	ctx, _ := initCommand(cfg)
+	user, err := lookupUserForCommand(ctx, *id, *email)
+	if err != nil {
+		log.Fatalf("[USER] %v", err)
+	}
+	if user.LegalHold == !*release {
+		log.Printf("[USER] Legal hold for %s is already %s", user.ID, holdState(user.LegalHold))
+		return
+	}
+	user.LegalHold = !*release
+	user.UpdatedAt = time.Now()
+	if err := userStore.Update(ctx, user); err != nil {
+		log.Fatalf("[USER] Failed to update %s: %v", user.ID, err)
+	}
+	auditEvent(ctx, user.ID, auditLegalHold, "Legal hold %s via CLI: %s", holdState(user.LegalHold), user.ID)
+}
+
+// runUserSetPassword implements
+// `server user set-password (--email E | --id ID) [--password-file F]`, e.g.
+// for users migrated without a password hash. The password is read like
+// create-admin's.
+func runUserSetPassword(args []string) {
+	fs := flag.NewFlagSet("user set-password", flag.ExitOnError)
+	email := fs.String("email", "", "user email")
+	id := fs.String("id", "", "user ID")
+	passwordFile := fs.String("password-file", "", "file containing the password")
+	cfg := loadCommandConfig(fs, args)
+
+	password, err := readAdminPassword(*passwordFile)
+	if err != nil {
+		log.Fatalf("[USER] %v", err)
+	}
+	if err := validate.Var(password, "required,min=8"); err != nil {
+		log.Fatalf("[USER] Invalid password: %v", err)
+	}
+
+	ctx, _ := initCommand(cfg)
+	user, err := lookupUserForCommand(ctx, *id, *email)
+	if err != nil {
+		log.Fatalf("[USER] %v", err)
+	}
+	if user.Password, err = HashPassword(password); err != nil {
+		log.Fatalf("[USER] Password hashing failed: %v", err)
+	}
+	user.UpdatedAt = time.Now()
+	if err := userStore.Update(ctx, user); err != nil {
+		log.Fatalf("[USER] Failed to update %s: %v", user.ID, err)
+	}
+	auditEvent(ctx, user.ID, auditPasswordReset, "Password set via CLI: %s", user.ID)
+}
+
+func holdState(held bool) string {
+	if held {
+		return "placed"
+	}
+	return "released"
+}
+
+// lookupUserForCommand resolves --id or --email (exactly one)
+func lookupUserForCommand(ctx context.Context, id, email string) (*User, error) {
+	switch {
+	case id != "" && email != "":
+		return nil, fmt.Errorf("use either --id or --email, not both")
+	case id != "":
+		return userStore.GetByID(ctx, id)
+	case email != "":
+		return userStore.GetByEmail(ctx, email)
+	default:
+		return nil, fmt.Errorf("--id or --email is required")
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// ============================================================================
+// User Records: ID-keyed storage with blind-indexed email lookup (CONFIDENTIALITY)
+// ============================================================================
+//
+// Storage backends: see user_store.go. Redis keys (primary store without a
+// database, read cache with one):
+//   user:<id>          storedUser JSON (email encrypted with the user's DEK)
+//   user:idx:<hmac>    user ID, where hmac = HMAC-SHA256(BLIND_INDEX_KEY, canonical email)
+//   user:<id>:dek      wrapped data key (envelope.go; in the keystore Redis if separate)
+//
+// Redis KEYS/SCAN therefore never reveals an email address.
+
+var (
+	errUserNotFound = errors.New("user not found")
+	errEmailTaken   = errors.New("email already registered")
+)
+
+// storedUser is the at-rest form of User. Unlike User it keeps the password
+// hash, which User hides from JSON responses.
+type storedUser struct {
+	ID           string    `json:"id"`
+	EmailIndex   string    `json:"email_index"`
+	EmailEnc     string    `json:"email_enc"`
+	PasswordHash string    `json:"password_hash"`
+	FullName     string    `json:"full_name"`
+	Role         string    `json:"role,omitempty"`
+	Active       bool      `json:"active"`
+	LegalHold    bool      `json:"legal_hold,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+func userKey(userID string) string {
+	return "user:" + userID
+}
+
+func userIndexKey(index string) string {
+	return "user:idx:" + index
+}
+
+// canonicalEmail normalizes an email for indexing (case and whitespace only;
+// provider-specific rules like Gmail dots are not applied)
+func canonicalEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// emailBlindIndex returns the keyed HMAC of the canonical email
+func emailBlindIndex(email string) string {
+	mac := hmac.New(sha256.New, securityConfig.BlindIndexKey)
+	mac.Write([]byte(canonicalEmail(email)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// userEmailField names the encrypted email for userAAD
+const userEmailField = "user:email"
+
+// sealUser encrypts the email and returns the at-rest form
+func sealUser(ctx context.Context, u *User) (*storedUser, error) {
+	emailEnc, err := encryptForUser(ctx, u.ID, userEmailField, u.Email)
+	if err != nil {
+		return nil, err
+	}
+	return &storedUser{
+		ID:           u.ID,
+		EmailIndex:   emailBlindIndex(u.Email),
+		EmailEnc:     emailEnc,
+		PasswordHash: u.Password,
+		FullName:     u.FullName,
+		Role:         u.Role,
+		Active:       u.Active,
+		LegalHold:    u.LegalHold,
+		CreatedAt:    u.CreatedAt,
+		UpdatedAt:    u.UpdatedAt,
+	}, nil
+}
+
+// openUser decrypts the email of an at-rest user
+func openUser(ctx context.Context, stored *storedUser) (*User, error) {
+	email, err := decryptForUser(ctx, stored.ID, userEmailField, stored.EmailEnc)
+	if err != nil {
+		return nil, err
+	}
+	if stored.Role == "" {
+		stored.Role = roleUser // records written before roles existed
+	}
+	return &User{
+		ID:        stored.ID,
+		Email:     email,
+		Password:  stored.PasswordHash,
+		FullName:  stored.FullName,
+		Role:      stored.Role,
+		Active:    stored.Active,
+		LegalHold: stored.LegalHold,
+		CreatedAt: stored.CreatedAt,
+		UpdatedAt: stored.UpdatedAt,
+	}, nil
+}
+
+// marshalUser encrypts the email and returns the stored JSON (Redis form)
+func marshalUser(ctx context.Context, u *User) ([]byte, error) {
+	stored, err := sealUser(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(stored)
+}
+
+// unmarshalUser decodes stored JSON and decrypts the email
+func unmarshalUser(ctx context.Context, data []byte) (*User, error) {
+	var stored storedUser
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, err
+	}
+	return openUser(ctx, &stored)
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// ============================================================================
+// UserStore: durable user storage (PostgreSQL when configured, else the KV store)
+// ============================================================================
+
+// UserStore persists users. Implementations enforce unique emails (via the
+// blind index) and return errUserNotFound / errEmailTaken.
+type UserStore interface {
+	Create(ctx context.Context, u *User) error
+	GetByID(ctx context.Context, id string) (*User, error)
+	GetByEmail(ctx context.Context, email string) (*User, error)
+	Update(ctx context.Context, u *User) error
+	Deactivate(ctx context.Context, id string) error
+	Delete(ctx context.Context, id string) error // right to erasure
+	// LegalHolds returns the IDs of users whose data must not be purged or erased
+	LegalHolds(ctx context.Context) ([]string, error)
+}
+
+var userStore UserStore
+
+// initUserStore selects the backend: PostgreSQL (with an optional KV read
+// cache) when a database is configured, otherwise the KV store without expiry.
+func initUserStore(db *sql.DB, cfg DatabaseConfig) {
+	if db == nil {
+		userStore = kvUserStore{}
+		infof("[STORE] Users: key-value store (no database configured)")
+		return
+	}
+	pg := newPostgresUserStore(db)
+	if cfg.UserCacheTTL > 0 {
+		userStore = newCachedUserStore(pg, cfg.UserCacheTTL.D())
+		infof("[STORE] Users: PostgreSQL, read cache (TTL %v)", cfg.UserCacheTTL.D())
+		return
+	}
+	userStore = pg
+	infof("[STORE] Users: PostgreSQL")
+}
+
+// ----------------------------------------------------------------------------
+// Key-value store (primary: Redis or memory) - records never expire
+// ----------------------------------------------------------------------------
+
+type kvUserStore struct{}
+
+// Create reserves the email via its blind index; SETNX also closes the
+// check-then-create race between concurrent registrations (INTEGRITY).
+func (kvUserStore) Create(ctx context.Context, u *User) error {
+	indexKey := userIndexKey(emailBlindIndex(u.Email))
+	reserved, err := kv.SetNX(ctx, indexKey, []byte(u.ID), 0)
+	if err != nil {
+		return err
+	}
+	if !reserved {
+		return errEmailTaken
+	}
+
+	// Store user keyed by ID, email encrypted (CONFIDENTIALITY: no emails in Redis keys)
+	userJSON, err := marshalUser(ctx, u)
+	if err == nil {
+		err = kv.Set(ctx, userKey(u.ID), userJSON, 0)
+	}
+	if err != nil {
+		kv.Del(ctx, indexKey)
+		return err
+	}
+	return nil
+}
+
+func (kvUserStore) GetByID(ctx context.Context, id string) (*User, error) {
+	data, err := kv.Get(ctx, userKey(id))
+	if err == errKeyNotFound {
+		return nil, errUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return unmarshalUser(ctx, data)
+}
+
+func (s kvUserStore) GetByEmail(ctx context.Context, email string) (*User, error) {
+	userID, err := kv.Get(ctx, userIndexKey(emailBlindIndex(email)))
+	if err == errKeyNotFound {
+		return nil, errUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.GetByID(ctx, string(userID))
+}
+
+// Update rewrites the record; an email change moves the blind index
+func (kvUserStore) Update(ctx context.Context, u *User) error {
+	raw, err := kv.Get(ctx, userKey(u.ID))
+	if err == errKeyNotFound {
+		return errUserNotFound
+	}
+	if err != nil {
+		return err
+	}
+	var old storedUser
+	if err := json.Unmarshal(raw, &old); err != nil {
+		return err
+	}
+	stored, err := sealUser(ctx, u)
+	if err != nil {
+		return err
+	}
+	if stored.EmailIndex != old.EmailIndex {
+		reserved, err := kv.SetNX(ctx, userIndexKey(stored.EmailIndex), []byte(u.ID), 0)
+		if err != nil {
+			return err
+		}
+		if !reserved {
+			return errEmailTaken
+		}
+	}
+	userJSON, err := json.Marshal(stored)
+	if err != nil {
+		return err
+	}
+	if err := kv.Set(ctx, userKey(u.ID), userJSON, 0); err != nil {
+		return err
+	}
+	if stored.EmailIndex != old.EmailIndex && old.EmailIndex != "" {
+		kv.Del(ctx, userIndexKey(old.EmailIndex))
+	}
+	return nil
+}
+
+func (s kvUserStore) Deactivate(ctx context.Context, id string) error {
+	return deactivateVia(ctx, s, id)
+}
+
+func (kvUserStore) Delete(ctx context.Context, id string) error {
+	return deleteKVUser(ctx, id)
+}
+
+// LegalHolds scans user:<id> records (there is no index; holds are rare and
+// only read by the retention job)
+func (kvUserStore) LegalHolds(ctx context.Context) ([]string, error) {
+	var ids []string
+	var cursor uint64
+	for {
+		keys, next, err := kv.Scan(ctx, cursor, "user:*", 500)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			if strings.Count(key, ":") != 1 || strings.Contains(key, "@") {
+				continue // blind index, DEK or legacy key
+			}
+			raw, err := kv.Get(ctx, key)
+			if err != nil {
+				continue // deleted meanwhile
+			}
+			var stored storedUser
+			if json.Unmarshal(raw, &stored) == nil && stored.LegalHold {
+				ids = append(ids, stored.ID)
+			}
+		}
+		if next == 0 {
+			return ids, nil
+		}
+		cursor = next
+	}
+}
+
+// deactivateVia implements Deactivate with GetByID + Update
+func deactivateVia(ctx context.Context, s UserStore, id string) error {
+	u, err := s.GetByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	u.Active = false
+	u.UpdatedAt = time.Now()
+	return s.Update(ctx, u)
+}
+
+// deleteKVUser removes user:<id> and its blind index entry
+func deleteKVUser(ctx context.Context, id string) error {
+	if data, err := kv.Get(ctx, userKey(id)); err == nil {
+		var stored storedUser
+		if json.Unmarshal(data, &stored) == nil && stored.EmailIndex != "" {
+			kv.Del(ctx, userIndexKey(stored.EmailIndex))
+		}
+	}
+	_, err := kv.Del(ctx, userKey(id))
+	return err
+}
+
+// ----------------------------------------------------------------------------
+// Read cache in front of a primary store (cache-aside, see cache_aside.go)
+// ----------------------------------------------------------------------------
+
+// cachedUserStore caches users by ID and the email blind index -> ID
+// mapping, including "not found" (negative TTL). Writes go to the primary
+// and invalidate around it. Cache errors are logged and fall through.
+type cachedUserStore struct {
+	primary UserStore
+	byID    *Cache[User]
+	byEmail *Cache[string] // blind index -> user ID
+}
+
+func newCachedUserStore(primary UserStore, ttl time.Duration) *cachedUserStore {
+	return &cachedUserStore{
+		primary: primary,
+		byID:    newCache[User]("user", ttl, errUserNotFound, userCacheCodec()),
+		byEmail: newCache[string]("user-email", ttl, errUserNotFound, jsonCacheCodec[string]()),
+	}
+}
+
+// userCacheCodec stores users sealed like the primary records (email
+// encrypted under the user's data key)
+func userCacheCodec() cacheCodec[User] {
+	return cacheCodec[User]{
+		encode: func(ctx context.Context, _ string, u *User) ([]byte, error) {
+			return marshalUser(ctx, u)
+		},
+		decode: func(ctx context.Context, _ string, data []byte) (*User, error) {
+			return unmarshalUser(ctx, data)
+		},
+	}
+}
+
+// Create drops cached "not found" entries for the new ID and email
+func (c *cachedUserStore) Create(ctx context.Context, u *User) error {
+	if err := c.primary.Create(ctx, u); err != nil {
+		return err
+	}
+	c.byID.Invalidate(ctx, u.ID)
+	c.byEmail.Invalidate(ctx, emailBlindIndex(u.Email))
+	return nil
+}
+
+func (c *cachedUserStore) GetByID(ctx context.Context, id string) (*User, error) {
+	u, _, err := c.byID.Get(ctx, id, func(ctx context.Context) (*User, error) {
+		return c.primary.GetByID(ctx, id)
+	})
+	return u, err
+}
+
+// GetByEmail resolves the blind index through the cache, then the user by
+// ID. An entry left over from an email change is detected and dropped.
+func (c *cachedUserStore) GetByEmail(ctx context.Context, email string) (*User, error) {
+	index := emailBlindIndex(email)
+	id, _, err := c.byEmail.Get(ctx, index, func(ctx context.Context) (*string, error) {
+		u, err := c.primary.GetByEmail(ctx, email)
+		if err != nil {
+			return nil, err
+		}
+		c.byID.Set(ctx, u.ID, u)
+		return &u.ID, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	u, err := c.GetByID(ctx, *id)
+	if err == nil && emailBlindIndex(u.Email) == index {
+		return u, nil
+	}
+	if err != nil && err != errUserNotFound {
+		return nil, err
+	}
+	c.byEmail.Invalidate(ctx, index)
+	return c.primary.GetByEmail(ctx, email)
+}
+
+func (c *cachedUserStore) Update(ctx context.Context, u *User) error {
+	c.byID.Invalidate(ctx, u.ID)
+	if err := c.primary.Update(ctx, u); err != nil {
+		return err
+	}
+	// again, in case a read refilled it meanwhile; the new email may be cached as not found
+	c.byID.Invalidate(ctx, u.ID)
+	c.byEmail.Invalidate(ctx, emailBlindIndex(u.Email))
+	return nil
+}
+
+func (c *cachedUserStore) Deactivate(ctx context.Context, id string) error {
+	c.byID.Invalidate(ctx, id)
+	if err := c.primary.Deactivate(ctx, id); err != nil {
+		return err
+	}
+	c.byID.Invalidate(ctx, id)
+	return nil
+}
+
+// LegalHolds is not cached (read by the retention job only)
+func (c *cachedUserStore) LegalHolds(ctx context.Context) ([]string, error) {
+	return c.primary.LegalHolds(ctx)
+}
+
+func (c *cachedUserStore) Delete(ctx context.Context, id string) error {
+	c.byID.Invalidate(ctx, id)
+	if err := c.primary.Delete(ctx, id); err != nil {
+		return err
+	}
+	c.byID.Invalidate(ctx, id) // the email entry is dropped on its next use
+	return nil
+}
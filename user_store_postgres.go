@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// ============================================================================
+// PostgreSQL UserStore
+// ============================================================================
+//
+// Uniqueness is enforced by the database (users_email_index_key), not by a
+// read-then-write check, so concurrent registrations cannot both succeed.
+// Only the blind index and the DEK-encrypted email are stored (CONFIDENTIALITY).
+
+const userColumns = `id, email_index, email_enc, password_hash, full_name, role, active, legal_hold, created_at, updated_at`
+
+type postgresUserStore struct {
+	db *sql.DB
+}
+
+// newPostgresUserStore uses the users table (migrations/0001_create_users.up.sql)
+func newPostgresUserStore(db *sql.DB) *postgresUserStore {
+	return &postgresUserStore{db: db}
+}
+
+func (s *postgresUserStore) Create(ctx context.Context, u *User) error {
+	stored, err := sealUser(ctx, u)
+	if err != nil {
+		return err
+	}
+	return s.insertStored(ctx, stored)
+}
+
+func (s *postgresUserStore) GetByID(ctx context.Context, id string) (*User, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, errUserNotFound // e.g. a legacy-token or signing-key principal
+	}
+	return s.getOne(ctx, `SELECT `+userColumns+` FROM users WHERE id = $1`, id)
+}
+
+func (s *postgresUserStore) GetByEmail(ctx context.Context, email string) (*User, error) {
+	return s.getOne(ctx, `SELECT `+userColumns+` FROM users WHERE email_index = $1`, emailBlindIndex(email))
+}
+
+func (s *postgresUserStore) Update(ctx context.Context, u *User) error {
+	stored, err := sealUser(ctx, u)
+	if err != nil {
+		return err
+	}
+	res, err := s.db.ExecContext(ctx,
+		`UPDATE users SET email_index = $2, email_enc = $3, password_hash = $4, full_name = $5,
+		        role = $6, active = $7, legal_hold = $8, updated_at = $9
+		  WHERE id = $1`,
+		stored.ID, stored.EmailIndex, stored.EmailEnc, stored.PasswordHash, stored.FullName,
+		stored.Role, stored.Active, stored.LegalHold, stored.UpdatedAt)
+	if err != nil {
+		return translateUserError(err)
+	}
+	return requireOneRow(res)
+}
+
+func (s *postgresUserStore) Deactivate(ctx context.Context, id string) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return errUserNotFound
+	}
+	res, err := s.db.ExecContext(ctx, `UPDATE users SET active = FALSE, updated_at = now() WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	return requireOneRow(res)
+}
+
+func (s *postgresUserStore) Delete(ctx context.Context, id string) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil
+	}
+	_, err := s.db.ExecContext(ctx, `DELETE FROM users WHERE id = $1`, id)
+	return err
+}
+
+func (s *postgresUserStore) LegalHolds(ctx context.Context) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT id FROM users WHERE legal_hold`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// exists reports whether a user ID is present
+func (s *postgresUserStore) exists(ctx context.Context, id string) (bool, error) {
+	var found bool
+	err := s.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM users WHERE id = $1)`, id).Scan(&found)
+	return found, err
+}
+
+// insertStored inserts an already-encrypted record (also used by migrate up)
+func (s *postgresUserStore) insertStored(ctx context.Context, stored *storedUser) error {
+	if stored.Role == "" {
+		stored.Role = roleUser
+	}
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO users (`+userColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+		stored.ID, stored.EmailIndex, stored.EmailEnc, stored.PasswordHash, stored.FullName,
+		stored.Role, stored.Active, stored.LegalHold, stored.CreatedAt, stored.UpdatedAt)
+	return translateUserError(err)
+}
+
+// postgresUsers returns the PostgreSQL store behind userStore, if any
+func postgresUsers() *postgresUserStore {
+	switch s := userStore.(type) {
+	case *postgresUserStore:
+		return s
+	case *cachedUserStore:
+		pg, _ := s.primary.(*postgresUserStore)
+		return pg
+	}
+	return nil
+}
+
+// getOne runs a single-user query and decrypts the result
+func (s *postgresUserStore) getOne(ctx context.Context, query string, arg string) (*User, error) {
+	var stored storedUser
+	err := s.db.QueryRowContext(ctx, query, arg).Scan(
+		&stored.ID, &stored.EmailIndex, &stored.EmailEnc, &stored.PasswordHash, &stored.FullName,
+		&stored.Role, &stored.Active, &stored.LegalHold, &stored.CreatedAt, &stored.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return openUser(ctx, &stored)
+}
+
+// translateUserError maps a unique violation on the email index to errEmailTaken
+func translateUserError(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" && pqErr.Constraint == "users_email_index_key" {
+		return errEmailTaken
+	}
+	return err
+}
+
+// requireOneRow returns errUserNotFound when an UPDATE matched nothing
+func requireOneRow(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return errUserNotFound
+	}
+	return nil
+}
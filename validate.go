@@ -3,6 +3,7 @@ package main
 import (
 	"encoding/json"
 	"net/http"
+	"strings"
 
 	"github.com/go-playground/validator/v10"
 )
@@ -11,6 +12,15 @@ var validate *validator.Validate
 
 func init() {
 	validate = validator.New()
+	_ = validate.RegisterValidation("retention_period", func(fl validator.FieldLevel) bool {
+		_, err := parseRetentionPeriod(fl.Field().String())
+		return err == nil
+	})
+	// Stored values are told apart from ciphertext by the "enc:" prefix
+	// alone, so plaintext must never carry it
+	_ = validate.RegisterValidation("not_ciphertext", func(fl validator.FieldLevel) bool {
+		return !looksEncrypted(fl.Field().String())
+	})
 }
 
 type UserInput struct {
@@ -18,6 +28,11 @@ type UserInput struct {
 	Name  string `json:"name" validate:"required,min=3"`
 }
 
+// looksEncrypted reports whether a value would be read back as ciphertext
+func looksEncrypted(s string) bool {
+	return strings.HasPrefix(s, ciphertextPrefix)
+}
+
 func validateInput[T any](w http.ResponseWriter, input *T) bool {
 	err := validate.Struct(input)
 	if err != nil {